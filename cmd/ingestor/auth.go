@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireToken rejects requests without "Authorization: Bearer <-auth-token>".
+// Handlers are returned unchanged when no token is configured.
+func requireToken(handler http.HandlerFunc) http.HandlerFunc {
+	if *authToken == "" {
+		return handler
+	}
+	want := []byte(*authToken)
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="blobsearch"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
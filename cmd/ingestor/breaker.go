@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is returned for writes attempted while the circuit breaker is open
+var ErrBreakerOpen = errors.New("storage circuit breaker is open")
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops calling a failing backend after repeated failures. After
+// the cooldown a single half-open probe is let through; its outcome closes the
+// breaker again or restarts the cooldown.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     string
+	openedAt  time.Time
+	probing   bool
+}
+
+// NewCircuitBreaker creates a breaker that opens after threshold consecutive failures
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     breakerClosed,
+	}
+}
+
+// Allow reports whether a call may proceed
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return ErrBreakerOpen
+		}
+		cb.state = breakerHalfOpen
+		cb.probing = true
+		log.Printf("Storage circuit breaker half-open, probing backend")
+		return nil
+	case breakerHalfOpen:
+		if cb.probing {
+			return ErrBreakerOpen
+		}
+		cb.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record records the outcome of an allowed call
+func (cb *CircuitBreaker) Record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+	if err == nil {
+		if cb.state != breakerClosed {
+			log.Printf("Storage circuit breaker closed, backend recovered")
+		}
+		cb.state = breakerClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == breakerHalfOpen || cb.failures >= cb.threshold {
+		if cb.state != breakerOpen {
+			log.Printf("Storage circuit breaker open after %d consecutive failures (cooldown %v)", cb.failures, cb.cooldown)
+		}
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// State returns closed, open, or half-open
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// breakerStorage guards another backend's writes with a circuit breaker
+type breakerStorage struct {
+	Storage
+	breaker *CircuitBreaker
+}
+
+func (s *breakerStorage) Put(ctx context.Context, key string, data []byte) error {
+	if err := s.breaker.Allow(); err != nil {
+		return err
+	}
+	err := s.Storage.Put(ctx, key, data)
+	s.breaker.Record(err)
+	return err
+}
+
+// BreakerState returns the state of the storage circuit breaker
+func (s *breakerStorage) BreakerState() string {
+	return s.breaker.State()
+}
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+// hllPrecision sets 2^14 registers per sketch (16 KiB, ~0.8% standard error)
+const hllPrecision = 14
+
+// HyperLogLog estimates the number of distinct values added to it
+type HyperLogLog struct {
+	registers []uint8
+}
+
+// NewHyperLogLog creates an empty sketch
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+// Add records a value
+func (h *HyperLogLog) Add(value string) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	x := mix64(hasher.Sum64())
+
+	idx := x >> (64 - hllPrecision)
+	// Rank of the first set bit in the remaining bits; the OR guards the all-zero case
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Estimate returns the approximate number of distinct values added
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// Linear counting is more accurate while many registers are still empty
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// mix64 spreads FNV output across all bits (splitmix64 finalizer)
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// CardinalityTracker keeps a HyperLogLog sketch per configured field
+// (-cardinality-fields) for the lifetime of the ingestor
+type CardinalityTracker struct {
+	mu       sync.Mutex
+	sketches map[string]*HyperLogLog
+}
+
+// NewCardinalityTracker creates a tracker for a comma-separated list of field paths
+func NewCardinalityTracker(spec string) *CardinalityTracker {
+	ct := &CardinalityTracker{sketches: make(map[string]*HyperLogLog)}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			ct.sketches[field] = NewHyperLogLog()
+		}
+	}
+	return ct
+}
+
+// Observe adds the configured fields' values from a parsed log line
+func (ct *CardinalityTracker) Observe(fields map[string]interface{}) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	for field, sketch := range ct.sketches {
+		if value := lookupString(fields, field); value != "" {
+			sketch.Add(value)
+		}
+	}
+}
+
+// Estimates returns the approximate distinct count per field
+func (ct *CardinalityTracker) Estimates() map[string]uint64 {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	estimates := make(map[string]uint64, len(ct.sketches))
+	for field, sketch := range ct.sketches {
+		estimates[field] = sketch.Estimate()
+	}
+	return estimates
+}
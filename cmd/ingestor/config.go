@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// liveConfigFlags are the extraction knobs that can be changed on SIGHUP
+// without restarting; everything else in -config only applies at startup
+var liveConfigFlags = map[string]bool{
+	"timestamp-fields":     true,
+	"level-fields":         true,
+	"drop-fields":          true,
+	"infer-level-keywords": true,
+	"level-keywords":       true,
+	"capture-exceptions":   true,
+	"exception-fields":     true,
+	"service-fields":       true,
+	"host-fields":          true,
+	"gelf-level-map":       true,
+}
+
+// liveConfig is an immutable snapshot of the live-reloadable settings. A
+// reload builds a new snapshot and swaps it in, so readers never race with
+// flag updates or see a half-applied configuration.
+type liveConfig struct {
+	values            map[string]string // flag-style values, for logging changes
+	timestampFields   string
+	levelFields       string
+	dropFields        string
+	inferLevel        bool
+	levelKeywordRules []levelKeywordRule
+	captureExceptions bool
+	exceptionPaths    ExceptionFieldPaths
+	serviceFields     string
+	hostFields        string
+	gelfLevelMap      map[int]string
+}
+
+var activeConfig atomic.Pointer[liveConfig]
+
+// currentConfig returns the live settings in effect
+func currentConfig() *liveConfig {
+	return activeConfig.Load()
+}
+
+// readConfigFile reads flag settings from a file with one "name = value" per
+// line. Blank lines and lines starting with # are ignored.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	settings := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected name = value", path, lineNum)
+		}
+		name = strings.TrimLeft(strings.TrimSpace(name), "-")
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s:%d: unknown flag %q", path, lineNum, name)
+		}
+		settings[name] = strings.TrimSpace(value)
+	}
+	return settings, scanner.Err()
+}
+
+// commandLineFlags holds the flags set explicitly on the command line, which
+// take precedence over the config file
+var commandLineFlags = make(map[string]bool)
+
+// applyConfigFile sets flags from the config file at startup
+func applyConfigFile(path string) error {
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Record command-line flags before the file sets any others
+	flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+
+	for name, value := range settings {
+		if commandLineFlags[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid -%s in %s: %v", name, path, err)
+		}
+	}
+	return nil
+}
+
+// liveFlagValues returns the startup values of the live-reloadable flags.
+// Flags are not modified after startup; reloads only replace the snapshot.
+func liveFlagValues() map[string]string {
+	values := make(map[string]string)
+	for name := range liveConfigFlags {
+		values[name] = flag.Lookup(name).Value.String()
+	}
+	return values
+}
+
+// newLiveConfig validates and parses flag-style values into a snapshot
+func newLiveConfig(values map[string]string) (*liveConfig, error) {
+	cfg := &liveConfig{
+		values:          values,
+		timestampFields: values["timestamp-fields"],
+		levelFields:     values["level-fields"],
+		dropFields:      values["drop-fields"],
+		serviceFields:   values["service-fields"],
+		hostFields:      values["host-fields"],
+	}
+
+	var err error
+	if cfg.inferLevel, err = strconv.ParseBool(values["infer-level-keywords"]); err != nil {
+		return nil, fmt.Errorf("invalid -infer-level-keywords: %v", err)
+	}
+	if cfg.captureExceptions, err = strconv.ParseBool(values["capture-exceptions"]); err != nil {
+		return nil, fmt.Errorf("invalid -capture-exceptions: %v", err)
+	}
+
+	if cfg.captureExceptions {
+		if cfg.exceptionPaths, err = parseExceptionFields(values["exception-fields"]); err != nil {
+			return nil, fmt.Errorf("invalid -exception-fields: %v", err)
+		}
+	}
+	if cfg.inferLevel {
+		if cfg.levelKeywordRules, err = parseLevelKeywords(values["level-keywords"]); err != nil {
+			return nil, fmt.Errorf("invalid -level-keywords: %v", err)
+		}
+	}
+	if cfg.gelfLevelMap, err = parseGELFLevelMap(values["gelf-level-map"]); err != nil {
+		return nil, fmt.Errorf("invalid -gelf-level-map: %v", err)
+	}
+	return cfg, nil
+}
+
+// loadLiveConfig builds the initial live settings from the flags
+func loadLiveConfig() error {
+	cfg, err := newLiveConfig(liveFlagValues())
+	if err != nil {
+		return err
+	}
+	activeConfig.Store(cfg)
+	return nil
+}
+
+// watchConfigReload reloads the live settings from path on every SIGHUP
+func (li *LogIngestor) watchConfigReload(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := li.reloadConfig(path); err != nil {
+			log.Printf("Config reload failed, keeping previous settings: %v", err)
+		}
+	}
+}
+
+// reloadConfig validates the live settings in path and swaps them in as one
+// snapshot. Settings left out of the file revert to their startup values.
+func (li *LogIngestor) reloadConfig(path string) error {
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := liveFlagValues()
+	for name, value := range settings {
+		if !liveConfigFlags[name] || commandLineFlags[name] {
+			if flag.Lookup(name).Value.String() != value {
+				log.Printf("Config reload: ignoring -%s (requires restart or set on the command line)", name)
+			}
+			continue
+		}
+		values[name] = value
+	}
+
+	cfg, err := newLiveConfig(values)
+	if err != nil {
+		return err
+	}
+	previous := activeConfig.Swap(cfg)
+
+	var changed []string
+	for name, value := range values {
+		if previous == nil || previous.values[name] != value {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	for _, name := range changed {
+		log.Printf("Config reload: -%s = %s", name, values[name])
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// connGroup tracks the goroutines and open connections of the GELF and
+// syslog listeners, so shutdown can stop every producer before the final
+// flush. Work started after Close is refused.
+type connGroup struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newConnGroup() *connGroup {
+	return &connGroup{conns: make(map[net.Conn]struct{})}
+}
+
+// Go runs f in a tracked goroutine. It returns false without running f once
+// the group is closed.
+func (g *connGroup) Go(f func()) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		f()
+	}()
+	return true
+}
+
+// Serve handles conn in a tracked goroutine and closes it when handle
+// returns. Connections accepted after Close are closed immediately.
+func (g *connGroup) Serve(conn net.Conn, handle func(net.Conn)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		conn.Close()
+		return
+	}
+	g.conns[conn] = struct{}{}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer g.remove(conn)
+		handle(conn)
+	}()
+}
+
+func (g *connGroup) remove(conn net.Conn) {
+	g.mu.Lock()
+	delete(g.conns, conn)
+	g.mu.Unlock()
+	conn.Close()
+}
+
+// Close closes every open connection and waits for all tracked goroutines,
+// including the accept loops, to return
+func (g *connGroup) Close() {
+	g.mu.Lock()
+	g.closed = true
+	for conn := range g.conns {
+		conn.Close()
+	}
+	g.mu.Unlock()
+	g.wg.Wait()
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DimensionValue is a distinct value observed for a dimension
+type DimensionValue struct {
+	Value    string    `json:"value"`
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// DimensionTracker keeps bounded, expiring sets of distinct values per dimension
+// (services, hosts) so operators can discover what has logged recently
+type DimensionTracker struct {
+	mu        sync.Mutex
+	values    map[string]map[string]*DimensionValue
+	ttl       time.Duration
+	maxValues int
+}
+
+// NewDimensionTracker creates a tracker that forgets values unseen for ttl and
+// keeps at most maxValues values per dimension
+func NewDimensionTracker(ttl time.Duration, maxValues int) *DimensionTracker {
+	return &DimensionTracker{
+		values:    make(map[string]map[string]*DimensionValue),
+		ttl:       ttl,
+		maxValues: maxValues,
+	}
+}
+
+// Observe records a value for a dimension
+func (dt *DimensionTracker) Observe(dimension, value string) {
+	if value == "" {
+		return
+	}
+
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	values, ok := dt.values[dimension]
+	if !ok {
+		values = make(map[string]*DimensionValue)
+		dt.values[dimension] = values
+	}
+
+	now := time.Now()
+	if dv, ok := values[value]; ok {
+		dv.Count++
+		dv.LastSeen = now
+		return
+	}
+
+	// Evict the least recently seen value when the dimension is full
+	if dt.maxValues > 0 && len(values) >= dt.maxValues {
+		var oldest *DimensionValue
+		for _, dv := range values {
+			if oldest == nil || dv.LastSeen.Before(oldest.LastSeen) {
+				oldest = dv
+			}
+		}
+		delete(values, oldest.Value)
+	}
+
+	values[value] = &DimensionValue{Value: value, Count: 1, LastSeen: now}
+}
+
+// Snapshot returns the unexpired values per dimension, most frequent first
+func (dt *DimensionTracker) Snapshot() map[string][]DimensionValue {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	cutoff := time.Now().Add(-dt.ttl)
+	snapshot := make(map[string][]DimensionValue)
+	for dimension, values := range dt.values {
+		list := make([]DimensionValue, 0, len(values))
+		for key, dv := range values {
+			if dt.ttl > 0 && dv.LastSeen.Before(cutoff) {
+				delete(values, key)
+				continue
+			}
+			list = append(list, *dv)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			return list[i].Value < list[j].Value
+		})
+		snapshot[dimension] = list
+	}
+	return snapshot
+}
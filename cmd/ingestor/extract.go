@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ExtractField maps a JSON path to a typed, nullable parquet column
+type ExtractField struct {
+	Path   string
+	Column string
+	Type   string // string, int, float or bool
+}
+
+// extractFields is the parsed -extract-fields list; when empty, files use the
+// fixed LogEntry schema
+var extractFields []ExtractField
+
+// extractSchema is the LogEntry schema extended with the extracted columns
+var extractSchema *parquet.Schema
+
+// parseExtractFields parses "path:type,path:type" mappings. Column names are
+// the paths with dots replaced by underscores (attributes.http.status_code
+// becomes attributes_http_status_code).
+func parseExtractFields(spec string) ([]ExtractField, error) {
+	base := parquet.SchemaOf(LogEntry{})
+	columns := make(map[string]bool)
+	for _, field := range base.Fields() {
+		columns[field.Name()] = true
+	}
+
+	var extracted []ExtractField
+	for _, mapping := range strings.Split(spec, ",") {
+		mapping = strings.TrimSpace(mapping)
+		if mapping == "" {
+			continue
+		}
+
+		path, fieldType, ok := strings.Cut(mapping, ":")
+		path = strings.TrimSpace(path)
+		fieldType = strings.ToLower(strings.TrimSpace(fieldType))
+		if !ok || path == "" {
+			return nil, fmt.Errorf("invalid mapping %q (expected path:type)", mapping)
+		}
+		switch fieldType {
+		case "string", "int", "float", "bool":
+		default:
+			return nil, fmt.Errorf("unknown type %q for %s (expected string, int, float or bool)", fieldType, path)
+		}
+
+		column := strings.Map(func(r rune) rune {
+			if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return '_'
+		}, path)
+		if columns[column] {
+			return nil, fmt.Errorf("column %q for %s is already in the schema", column, path)
+		}
+		columns[column] = true
+
+		extracted = append(extracted, ExtractField{Path: path, Column: column, Type: fieldType})
+	}
+	return extracted, nil
+}
+
+// newExtractSchema builds the LogEntry schema plus one optional column per extracted field
+func newExtractSchema(extracted []ExtractField) *parquet.Schema {
+	group := parquet.Group{}
+	for _, field := range parquet.SchemaOf(LogEntry{}).Fields() {
+		group[field.Name()] = field
+	}
+
+	for _, field := range extracted {
+		var node parquet.Node
+		switch field.Type {
+		case "int":
+			node = parquet.Int(64)
+		case "float":
+			node = parquet.Leaf(parquet.DoubleType)
+		case "bool":
+			node = parquet.Leaf(parquet.BooleanType)
+		default:
+			node = parquet.String()
+		}
+		group[field.Column] = parquet.Optional(node)
+	}
+	return parquet.NewSchema("LogEntry", group)
+}
+
+// extractValues reads the -extract-fields values from a decoded line. Absent
+// fields, and values that don't convert to the column type, are nil.
+func extractValues(fields map[string]interface{}) []interface{} {
+	if len(extractFields) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, len(extractFields))
+	for i, field := range extractFields {
+		value, ok := lookupField(fields, field.Path)
+		if ok && value != nil {
+			values[i] = convertExtracted(value, field.Type)
+		}
+	}
+	return values
+}
+
+// convertExtracted coerces a decoded JSON scalar to a column type
+func convertExtracted(value interface{}, fieldType string) interface{} {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case json.Number:
+		text = v.String()
+	case bool:
+		text = strconv.FormatBool(v)
+	default:
+		return nil
+	}
+
+	switch fieldType {
+	case "int":
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(text, 64); err == nil && f == float64(int64(f)) {
+			return int64(f)
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	default:
+		return text
+	}
+	return nil
+}
+
+// extractWriter encodes entries with the extended schema. Rows are written
+// as maps so the extracted columns can sit beside the LogEntry ones.
+type extractWriter struct {
+	*parquet.Writer
+}
+
+func (w extractWriter) writeEntries(entries []LogEntry) error {
+	for _, entry := range entries {
+		row := entryColumns(entry)
+		for i, field := range extractFields {
+			var value interface{}
+			if i < len(entry.Extracted) {
+				value = entry.Extracted[i]
+			}
+			row[field.Column] = value
+		}
+		if err := w.Write(row); err != nil {
+			return fmt.Errorf("error writing to parquet: %w", err)
+		}
+	}
+	return nil
+}
+
+// entryColumns returns an entry's parquet columns by name, with zero-valued
+// optional columns as nil to match the generic writer
+func entryColumns(entry LogEntry) map[string]interface{} {
+	v := reflect.ValueOf(entry)
+	t := v.Type()
+	row := make(map[string]interface{}, t.NumField()+len(extractFields))
+	for i := 0; i < t.NumField(); i++ {
+		name, options, _ := strings.Cut(t.Field(i).Tag.Get("parquet"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if field := v.Field(i); strings.Contains(options, "optional") && field.IsZero() {
+			row[name] = nil
+		} else {
+			row[name] = field.Interface()
+		}
+	}
+	return row
+}
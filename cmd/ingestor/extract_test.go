@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParseExtractFields(t *testing.T) {
+	got, err := parseExtractFields(" traceId:string , attributes.http.status_code:INT,duration-ms:float")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ExtractField{
+		{Path: "traceId", Column: "traceId", Type: "string"},
+		{Path: "attributes.http.status_code", Column: "attributes_http_status_code", Type: "int"},
+		{Path: "duration-ms", Column: "duration_ms", Type: "float"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, spec := range []string{
+		"traceId",            // no type
+		"traceId:uuid",       // unknown type
+		":string",            // no path
+		"level:string",       // clashes with a LogEntry column
+		"a.b:string,a_b:int", // two paths naming one column
+	} {
+		if _, err := parseExtractFields(spec); err == nil {
+			t.Errorf("parseExtractFields(%q) should fail", spec)
+		}
+	}
+}
+
+func TestConvertExtracted(t *testing.T) {
+	tests := []struct {
+		value     interface{}
+		fieldType string
+		want      interface{}
+	}{
+		{json.Number("503"), "int", int64(503)},
+		{"503", "int", int64(503)},
+		{json.Number("5e2"), "int", int64(500)},
+		{json.Number("1.5"), "int", nil},
+		{json.Number("1.5"), "float", 1.5},
+		{"fast", "float", nil},
+		{true, "bool", true},
+		{"false", "bool", false},
+		{json.Number("42"), "string", "42"},
+		{map[string]interface{}{}, "string", nil}, // objects aren't scalars
+	}
+	for _, tt := range tests {
+		if got := convertExtracted(tt.value, tt.fieldType); got != tt.want {
+			t.Errorf("convertExtracted(%v, %s) = %#v, want %#v", tt.value, tt.fieldType, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeExtractedColumns(t *testing.T) {
+	defer func(fields []ExtractField, schema *parquet.Schema) {
+		extractFields, extractSchema = fields, schema
+	}(extractFields, extractSchema)
+
+	var err error
+	extractFields, err = parseExtractFields("attributes.http.status_code:int,traceId:string")
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractSchema = newExtractSchema(extractFields)
+
+	lines := []string{
+		`{"message":"ok","traceId":"abc","attributes":{"http":{"status_code":200}}}`,
+		`{"message":"no trace","attributes":{"http":{"status_code":"oops"}}}`,
+	}
+	entries := testEntries(len(lines))
+	for i, line := range lines {
+		entries[i].Extracted = extractValues(parseJSONFields(line))
+	}
+
+	data, err := encodeParquet(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, ok := file.Schema().Lookup("attributes_http_status_code")
+	if !ok || !status.Node.Optional() {
+		t.Fatal("status column missing or not optional")
+	}
+	trace, ok := file.Schema().Lookup("traceId")
+	if !ok {
+		t.Fatal("traceId column missing")
+	}
+
+	rows := make([]parquet.Row, len(lines))
+	reader := parquet.NewReader(file)
+	if n, err := reader.ReadRows(rows); n != len(lines) || (err != nil && err != io.EOF) {
+		t.Fatalf("read %d rows: %v", n, err)
+	}
+
+	column := func(row parquet.Row, index int) parquet.Value {
+		for _, value := range row {
+			if value.Column() == index {
+				return value
+			}
+		}
+		t.Fatalf("row has no column %d", index)
+		return parquet.Value{}
+	}
+	if v := column(rows[0], status.ColumnIndex); v.IsNull() || v.Int64() != 200 {
+		t.Errorf("row 0 status = %v, want 200", v)
+	}
+	if v := column(rows[0], trace.ColumnIndex); v.IsNull() || v.String() != "abc" {
+		t.Errorf("row 0 traceId = %v, want abc", v)
+	}
+	if v := column(rows[1], status.ColumnIndex); !v.IsNull() {
+		t.Errorf("row 1 status = %v, want null for an unconvertible value", v)
+	}
+	if v := column(rows[1], trace.ColumnIndex); !v.IsNull() {
+		t.Errorf("row 1 traceId = %v, want null for an absent field", v)
+	}
+}
@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// parseJSONFields decodes a JSON object log line, returning nil for anything
+// else. Numbers are kept as json.Number so nanosecond epochs stay exact.
+func parseJSONFields(line string) map[string]interface{} {
+	if !strings.HasPrefix(line, "{") {
+		return nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// lookupField resolves a dotted path such as "resource.service.name" against
+// decoded JSON. Keys may themselves contain dots (OpenTelemetry emits
+// {"resource": {"service.name": ...}}), so the longest literal key is tried
+// first at each level before descending into nested objects.
+func lookupField(fields map[string]interface{}, path string) (interface{}, bool) {
+	if fields == nil || path == "" {
+		return nil, false
+	}
+
+	if value, ok := fields[path]; ok {
+		return value, true
+	}
+
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i] != '.' {
+			continue
+		}
+		nested, ok := fields[path[:i]].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, ok := lookupField(nested, path[i+1:]); ok {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// lookupString returns the first configured field holding a scalar value, as a string
+func lookupString(fields map[string]interface{}, paths string) string {
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		value, ok := lookupField(fields, path)
+		if !ok || value == nil {
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case json.Number:
+			return v.String()
+		case float64, bool:
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// dropJSONFields removes the given (dotted) field paths from a JSON object line
+// and re-encodes it. Lines that are not JSON objects are returned unchanged.
+func dropJSONFields(line string, paths string) string {
+	if !strings.HasPrefix(line, "{") {
+		return line
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return line
+	}
+
+	dropped := false
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if path != "" && deleteField(fields, path) {
+			dropped = true
+		}
+	}
+	if !dropped {
+		return line
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(fields); err != nil {
+		return line
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// deleteField removes a dotted path using the same key resolution as lookupField
+func deleteField(fields map[string]interface{}, path string) bool {
+	if _, ok := fields[path]; ok {
+		delete(fields, path)
+		return true
+	}
+
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i] != '.' {
+			continue
+		}
+		nested, ok := fields[path[:i]].(map[string]interface{})
+		if ok && deleteField(nested, path[i+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExceptionFieldPaths holds the comma-separated field paths checked for each exception column
+type ExceptionFieldPaths struct {
+	Type       string
+	Message    string
+	StackTrace string
+}
+
+// parseExceptionFields parses "type=a|b,message=c,stacktrace=d" into field paths
+func parseExceptionFields(spec string) (ExceptionFieldPaths, error) {
+	var paths ExceptionFieldPaths
+	for _, mapping := range strings.Split(spec, ",") {
+		mapping = strings.TrimSpace(mapping)
+		if mapping == "" {
+			continue
+		}
+
+		column, fieldPaths, ok := strings.Cut(mapping, "=")
+		if !ok {
+			return paths, fmt.Errorf("invalid mapping %q (expected column=path|path)", mapping)
+		}
+		fieldPaths = strings.ReplaceAll(fieldPaths, "|", ",")
+
+		switch strings.TrimSpace(column) {
+		case "type":
+			paths.Type = fieldPaths
+		case "message":
+			paths.Message = fieldPaths
+		case "stacktrace":
+			paths.StackTrace = fieldPaths
+		default:
+			return paths, fmt.Errorf("unknown exception column %q (expected type, message, or stacktrace)", column)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLookupField(t *testing.T) {
+	fields := parseJSONFields(`{"level":"info","log":{"level":"warn"},"resource":{"service.name":"billing","service":{"version":"2"}}}`)
+
+	tests := []struct {
+		path string
+		want interface{}
+		ok   bool
+	}{
+		{"level", "info", true},
+		{"log.level", "warn", true},
+		{"resource.service.name", "billing", true}, // literal dotted key
+		{"resource.service.version", "2", true},    // nested objects
+		{"log.missing", nil, false},
+		{"level.nested", nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := lookupField(fields, tt.path)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("lookupField(%q) = %v, %v; want %v, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if _, ok := lookupField(nil, "level"); ok {
+		t.Error("lookupField on nil fields should find nothing")
+	}
+}
+
+func TestParseJSONFields(t *testing.T) {
+	if fields := parseJSONFields(`{"level":"info","level":"error"}`); fields["level"] != "error" {
+		t.Errorf("duplicate key: got %v, want the last value", fields["level"])
+	}
+	if fields := parseJSONFields(`{"n":17}`); fields["n"] != json.Number("17") {
+		t.Errorf("numbers should decode as json.Number, got %T", fields["n"])
+	}
+	for _, line := range []string{`level=info msg=ok`, `{"level":"info"`, `[1,2]`} {
+		if fields := parseJSONFields(line); fields != nil {
+			t.Errorf("parseJSONFields(%q) = %v, want nil", line, fields)
+		}
+	}
+}
+
+func TestLevelFromFields(t *testing.T) {
+	setLiveFlag(t, "level-fields", "level,log.level,severityNumber")
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`{"level":"WARNING"}`, "warn"},
+		{`{"log":{"level":"fatal"}}`, "error"},
+		{`{"severityNumber":9}`, "warn"},
+		{`{"level":"","log":{"level":"debug"}}`, "debug"}, // empty values are skipped
+		{`{"severity":"error"}`, "unknown"},               // not a configured field
+		{`not json`, "unknown"},
+	}
+	for _, tt := range tests {
+		if got := levelFromFields(parseJSONFields(tt.line)); got != tt.want {
+			t.Errorf("levelFromFields(%s) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestTimestampFromFields(t *testing.T) {
+	setLiveFlag(t, "timestamp-fields", "ts,event.time")
+
+	want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		line string
+		ok   bool
+	}{
+		{`{"ts":"2026-01-02T03:04:05Z"}`, true},
+		{`{"event":{"time":"2026-01-02 03:04:05"}}`, true},
+		{`{"ts":1767323045}`, true},
+		{`{"ts":"yesterday","event":{"time":1767323045000}}`, true}, // falls through to the next path
+		{`{"ts":"1999-01-01T00:00:00Z"}`, false},
+		{`{"time":"2026-01-02T03:04:05Z"}`, false},
+	}
+	for _, tt := range tests {
+		got, ok := timestampFromFields(parseJSONFields(tt.line))
+		if ok != tt.ok || (ok && !got.Equal(want)) {
+			t.Errorf("timestampFromFields(%s) = %v, %v; want %v", tt.line, got, ok, tt.ok)
+		}
+	}
+}
+
+func TestExtractLevelFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"json", `{"level":"WARNING","msg":"disk almost full"}`, "warn"},
+		{"json numeric severity", `{"severity":17,"msg":"payment failed"}`, "error"},
+		{"json without a level", `{"msg":"level=debug in the message"}`, "unknown"},
+		{"logfmt", `time=2026-01-02T03:04:05Z level=info msg="user logged in"`, "info"},
+		{"logfmt quoted", `msg="retrying" level="error"`, "error"},
+		{"logfmt numeric severity", `severity=10 msg=slow`, "warn"},
+		{"logfmt key suffix", `loglevel=debug msg=ignored`, "unknown"},
+		{"malformed json", `{"level":"error","msg":"truncated`, "error"},
+		{"malformed json numeric", `{"severity": 3, "msg": "truncated`, "debug"},
+		{"plain text", `connection reset by peer`, "unknown"},
+	}
+	for _, tt := range tests {
+		if got := extractLevel(tt.line, parseJSONFields(tt.line)); got != tt.want {
+			t.Errorf("%s: extractLevel(%s) = %q, want %q", tt.name, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimestampFallback(t *testing.T) {
+	want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	apache := time.Date(2023, 10, 11, 14, 32, 52, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		line string
+		want time.Time
+		ok   bool
+	}{
+		{"json", `{"timestamp":"2026-01-02T03:04:05Z","msg":"ok"}`, want, true},
+		{"json without a timestamp field", `{"msg":"proxied [Wed Oct 11 14:32:52 2023]"}`, apache, true},
+		{"logfmt", `time=2026-01-02T03:04:05Z level=info msg=ok`, want, true},
+		{"logfmt quoted", `msg=ok timestamp="2026-01-02 03:04:05"`, want, true},
+		{"logfmt epoch", `timestamp=1767323045 msg=ok`, want, true},
+		{"malformed json", `{"timestamp":"2026-01-02T03:04:05Z","msg":"truncated`, want, true},
+		{"malformed json epoch", `{"time": 1767323045000, "msg": "truncated`, want, true},
+		{"prefix", `2026-01-02T03:04:05Z worker started`, want, true},
+		{"none", `{"msg":"no time here"}`, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTimestamp(tt.line)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("%s: parseTimestamp(%s) = %v, %v; want %v, %v", tt.name, tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// forwardRetries is how many times a failed forward batch is retried
+const forwardRetries = 3
+
+// Forwarder POSTs accepted lines to a downstream collector (-forward-url) in
+// newline-delimited batches, independently of parquet flushing
+type Forwarder struct {
+	url       string
+	client    *http.Client
+	lines     chan string
+	batchSize int
+	interval  time.Duration
+	done      chan struct{}
+	mu        sync.RWMutex // guards closed against concurrent Enqueue
+	closed    bool
+
+	sent    atomic.Int64
+	errors  atomic.Int64
+	dropped atomic.Int64
+}
+
+// ForwarderStats is the forwarding section of /stats
+type ForwarderStats struct {
+	Sent       int64 `json:"lines_sent"`
+	Errors     int64 `json:"batch_errors"`
+	Dropped    int64 `json:"lines_dropped"`
+	QueueDepth int   `json:"queue_depth"`
+}
+
+// NewForwarder creates a forwarder and starts its background worker
+func NewForwarder(url string, queueSize, batchSize int, interval time.Duration) *Forwarder {
+	f := &Forwarder{
+		url:       url,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		lines:     make(chan string, queueSize),
+		batchSize: batchSize,
+		interval:  interval,
+		done:      make(chan struct{}),
+	}
+	go f.worker()
+	return f
+}
+
+// Enqueue queues a line for forwarding. Lines are dropped rather than
+// blocking ingestion when the downstream can't keep up, or after Close.
+func (f *Forwarder) Enqueue(line string) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		f.dropped.Add(1)
+		return
+	}
+
+	select {
+	case f.lines <- line:
+	default:
+		f.dropped.Add(1)
+	}
+}
+
+// Close sends any queued lines and stops the worker
+func (f *Forwarder) Close() {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.lines)
+	}
+	f.mu.Unlock()
+	<-f.done
+}
+
+// Stats returns forwarding counters
+func (f *Forwarder) Stats() ForwarderStats {
+	return ForwarderStats{
+		Sent:       f.sent.Load(),
+		Errors:     f.errors.Load(),
+		Dropped:    f.dropped.Load(),
+		QueueDepth: len(f.lines),
+	}
+}
+
+func (f *Forwarder) worker() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	var batch []string
+	for {
+		select {
+		case line, ok := <-f.lines:
+			if !ok {
+				f.send(batch)
+				return
+			}
+			batch = append(batch, line)
+			if len(batch) >= f.batchSize {
+				f.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			f.send(batch)
+			batch = nil
+		}
+	}
+}
+
+// send POSTs a batch, retrying with backoff before giving up on it
+func (f *Forwarder) send(batch []string) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var body bytes.Buffer
+	for _, line := range batch {
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+
+	var err error
+	backoff := 500 * time.Millisecond
+	for attempt := 0; attempt <= forwardRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = f.post(body.Bytes()); err == nil {
+			f.sent.Add(int64(len(batch)))
+			return
+		}
+	}
+
+	f.errors.Add(1)
+	log.Printf("Error forwarding %d lines to %s: %v", len(batch), f.url, err)
+}
+
+func (f *Forwarder) post(body []byte) error {
+	resp, err := f.client.Post(f.url, "application/x-ndjson", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
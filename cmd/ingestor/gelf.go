@@ -3,12 +3,22 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -55,12 +65,42 @@ func (g *GELFMessage) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// parseGELFLevelMap parses a mapping in the form "10=debug,20=info"
+func parseGELFLevelMap(spec string) (map[int]string, error) {
+	levels := make(map[int]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		num, level, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid GELF level mapping %q (expected number=level)", pair)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(num))
+		if err != nil {
+			return nil, fmt.Errorf("invalid GELF level %q: %v", num, err)
+		}
+		level = strings.ToLower(strings.TrimSpace(level))
+		if knownLevel(level) == "" {
+			return nil, fmt.Errorf("invalid level %q for GELF level %d (expected error, warn, info or debug)", level, n)
+		}
+		levels[n] = level
+	}
+	return levels, nil
+}
+
 // ProcessGELF processes a GELF message and converts it to a standard log entry
 func (li *LogIngestor) ProcessGELF(gelf GELFMessage) error {
 	// Try to parse level from the actual log message first (for JSON or structured logs)
 	levelStr := parseLevelFromMessage(gelf.ShortMessage)
 
-	// If we couldn't parse from message, fall back to GELF level (syslog 0-7)
+	// If we couldn't parse from message, use the custom level map for
+	// non-standard senders, then fall back to GELF level (syslog 0-7)
+	if mapped, ok := currentConfig().gelfLevelMap[gelf.Level]; ok && levelStr == "" {
+		levelStr = mapped
+	}
 	if levelStr == "" {
 		switch gelf.Level {
 		case 0, 1, 2: // Emergency, Alert, Critical
@@ -89,6 +129,10 @@ func (li *LogIngestor) ProcessGELF(gelf GELFMessage) error {
 		// GELF timestamp is Unix timestamp with decimal seconds
 		t := time.Unix(int64(gelf.Timestamp), int64((gelf.Timestamp-float64(int64(gelf.Timestamp)))*1e9))
 		logMap["timestamp"] = t.Format(time.RFC3339Nano)
+	} else if t, ok := parseTimestamp(gelf.ShortMessage); ok {
+		// Senders that omit the GELF timestamp often embed the event
+		// time in the wrapped JSON or logfmt line
+		logMap["timestamp"] = t.Format(time.RFC3339Nano)
 	} else {
 		logMap["timestamp"] = time.Now().Format(time.RFC3339Nano)
 	}
@@ -129,24 +173,10 @@ func (li *LogIngestor) ProcessGELF(gelf GELFMessage) error {
 // Returns empty string if no level found
 func parseLevelFromMessage(message string) string {
 	// Try 1: Check if message is JSON and extract "level" field
-	if strings.HasPrefix(message, "{") {
-		var logData map[string]interface{}
-		if err := json.Unmarshal([]byte(message), &logData); err == nil {
-			if level, ok := logData["level"].(string); ok {
-				level = strings.ToLower(level)
-				// Normalize variations
-				switch level {
-				case "warning":
-					return "warn"
-				case "err":
-					return "error"
-				case "trace":
-					return "debug"
-				case "fatal", "panic", "critical":
-					return "error"
-				case "error", "warn", "info", "debug":
-					return level
-				}
+	if fields := parseJSONFields(message); fields != nil {
+		if value, ok := fields["level"].(string); ok {
+			if level := knownLevel(normalizeLevel(value)); level != "" {
+				return level
 			}
 		}
 	}
@@ -154,51 +184,151 @@ func parseLevelFromMessage(message string) string {
 	// Try 2: Check for logrus text format: level=info
 	if strings.Contains(message, "level=") {
 		re := regexp.MustCompile(`level=(\w+)`)
-		matches := re.FindStringSubmatch(message)
-		if len(matches) > 1 {
-			level := strings.ToLower(matches[1])
-			// Normalize variations
-			switch level {
-			case "warning":
-				return "warn"
-			case "err":
-				return "error"
-			case "trace":
-				return "debug"
-			case "fatal", "panic", "critical":
-				return "error"
-			case "error", "warn", "info", "debug":
-				return level
-			}
+		if value, pos := lastSubmatch(re, message); pos >= 0 {
+			return knownLevel(normalizeLevel(value))
 		}
 	}
 
 	return ""
 }
 
-// StartGELFTCPServer starts a TCP server to receive GELF messages from Docker logging driver
-func StartGELFTCPServer(addr string, ingestor *LogIngestor) error {
+// knownLevel returns level if it is one of the standard levels, or ""
+func knownLevel(level string) string {
+	switch level {
+	case "error", "warn", "info", "debug":
+		return level
+	}
+	return ""
+}
+
+// GELFQueue is a bounded queue between GELF TCP reads and processing. When it
+// is full, connection readers block, which pauses reading and pushes
+// backpressure to senders through TCP flow control.
+type GELFQueue struct {
+	messages chan GELFMessage
+	ingestor *LogIngestor
+	stalls   atomic.Int64
+	mu       sync.Mutex // guards closed against concurrent Enqueue
+	closed   bool
+	senders  sync.WaitGroup // Enqueue calls that may still send
+	workers  sync.WaitGroup
+}
+
+// NewGELFQueue creates a queue holding up to size messages and starts workers
+// goroutines to process it
+func NewGELFQueue(size, workers int, ingestor *LogIngestor) *GELFQueue {
+	q := &GELFQueue{
+		messages: make(chan GELFMessage, size),
+		ingestor: ingestor,
+	}
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go q.worker()
+	}
+	return q
+}
+
+// Enqueue adds a message, blocking while the queue is full. Messages arriving
+// after Close are dropped.
+func (q *GELFQueue) Enqueue(msg GELFMessage) {
+	// Register as a sender under the lock, but block outside it so Close
+	// isn't held up behind a full queue
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		log.Printf("Dropping GELF message received during shutdown")
+		return
+	}
+	q.senders.Add(1)
+	q.mu.Unlock()
+	defer q.senders.Done()
+
+	select {
+	case q.messages <- msg:
+		return
+	default:
+	}
+
+	q.stalls.Add(1)
+	q.messages <- msg
+}
+
+// Depth returns the number of messages waiting to be processed
+func (q *GELFQueue) Depth() int {
+	return len(q.messages)
+}
+
+// Capacity returns the maximum queue depth
+func (q *GELFQueue) Capacity() int {
+	return cap(q.messages)
+}
+
+// Stalls returns how many times a reader had to wait for space in the queue
+func (q *GELFQueue) Stalls() int64 {
+	return q.stalls.Load()
+}
+
+// Close stops accepting messages and waits for queued ones to be processed
+func (q *GELFQueue) Close() {
+	q.mu.Lock()
+	wasClosed := q.closed
+	q.closed = true
+	q.mu.Unlock()
+
+	if !wasClosed {
+		// Workers keep draining, so blocked senders finish before the close
+		q.senders.Wait()
+		close(q.messages)
+	}
+	q.workers.Wait()
+}
+
+func (q *GELFQueue) worker() {
+	defer q.workers.Done()
+	for msg := range q.messages {
+		if err := q.ingestor.ProcessGELF(msg); err != nil {
+			log.Printf("Error processing GELF: %v", err)
+		}
+	}
+}
+
+// StartGELFTCPServer starts a TCP server to receive GELF messages from Docker
+// logging driver, over TLS when tlsConfig is set. It stops accepting
+// connections when ctx is cancelled; open connections are tracked in conns.
+func StartGELFTCPServer(ctx context.Context, addr string, tlsConfig *tls.Config, queue *GELFQueue, conns *connGroup) error {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on TCP: %v", err)
 	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
 	defer listener.Close()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
 
 	log.Printf("GELF TCP server listening on %s", addr)
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
 
 		// Handle each connection in a goroutine
-		go handleGELFConnection(conn, ingestor)
+		conns.Serve(conn, func(conn net.Conn) {
+			handleGELFConnection(conn, queue)
+		})
 	}
 }
 
-func handleGELFConnection(conn net.Conn, ingestor *LogIngestor) {
+func handleGELFConnection(conn net.Conn, queue *GELFQueue) {
 	defer conn.Close()
 
 	// GELF over TCP uses null-terminated messages
@@ -208,8 +338,18 @@ func handleGELFConnection(conn net.Conn, ingestor *LogIngestor) {
 	for {
 		n, err := conn.Read(readBuf)
 		if err != nil {
-			if err.Error() != "EOF" {
-				log.Printf("Error reading from connection: %v", err)
+			if err != io.EOF {
+				// Shutdown closes open connections; that is not a read error
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("Error reading from connection: %v", err)
+				}
+				return
+			}
+
+			// Many senders omit the null terminator on the last message,
+			// so treat any leftover bytes as a final message
+			if len(bytes.TrimSpace(buffer)) > 0 {
+				enqueueGELFBytes(buffer, queue)
 			}
 			return
 		}
@@ -240,23 +380,26 @@ func handleGELFConnection(conn net.Conn, ingestor *LogIngestor) {
 				continue
 			}
 
-			// Parse GELF message
-			var gelfMsg GELFMessage
-			if err := json.Unmarshal(messageBytes, &gelfMsg); err != nil {
-				log.Printf("Error parsing GELF message: %v", err)
-				continue
-			}
-
-			// Process the message
-			if err := ingestor.ProcessGELF(gelfMsg); err != nil {
-				log.Printf("Error processing GELF: %v", err)
-			}
+			enqueueGELFBytes(messageBytes, queue)
 		}
 	}
 }
 
-// StartGELFUDPServer starts a UDP server to receive GELF messages from Docker logging driver
-func StartGELFUDPServer(addr string, ingestor *LogIngestor) error {
+// enqueueGELFBytes parses a single raw GELF message and queues it for processing
+func enqueueGELFBytes(data []byte, queue *GELFQueue) {
+	var gelfMsg GELFMessage
+	if err := json.Unmarshal(data, &gelfMsg); err != nil {
+		log.Printf("Error parsing GELF message: %v", err)
+		return
+	}
+
+	queue.Enqueue(gelfMsg)
+}
+
+// StartGELFUDPServer starts a UDP server to receive GELF messages from Docker
+// logging driver. It stops reading when ctx is cancelled; message handlers
+// are tracked in conns.
+func StartGELFUDPServer(ctx context.Context, addr string, ingestor *LogIngestor, conns *connGroup) error {
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to resolve UDP address: %v", err)
@@ -267,23 +410,47 @@ func StartGELFUDPServer(addr string, ingestor *LogIngestor) error {
 		return fmt.Errorf("failed to listen on UDP: %v", err)
 	}
 	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
 
-	log.Printf("GELF UDP server listening on %s", addr)
+	log.Printf("GELF UDP server listening on %s", conn.LocalAddr())
 
-	// Buffer for incoming messages (GELF messages are typically under 8KB)
-	buffer := make([]byte, 8192)
+	// Large enough for any datagram; chunked messages are reassembled below
+	buffer := make([]byte, 65536)
+	assembler := newGELFChunkAssembler(gelfChunkTimeout)
 
 	for {
 		n, remoteAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
 			log.Printf("Error reading from UDP: %v", err)
 			continue
 		}
 
 		// Process GELF message in a goroutine to avoid blocking
-		go func(data []byte, addr *net.UDPAddr) {
+		data, addr := append([]byte(nil), buffer[:n]...), remoteAddr // copy: buffer is reused by the next read
+		conns.Go(func() {
+			payload, complete, err := assembler.Add(data)
+			if err != nil {
+				log.Printf("Error reading GELF chunk from %s: %v", addr, err)
+				return
+			}
+			if !complete {
+				return
+			}
+
+			payload, err = decompressGELF(payload)
+			if err != nil {
+				log.Printf("Error decompressing GELF message from %s: %v", addr, err)
+				return
+			}
+
 			var gelfMsg GELFMessage
-			if err := json.Unmarshal(data, &gelfMsg); err != nil {
+			if err := json.Unmarshal(payload, &gelfMsg); err != nil {
 				log.Printf("Error parsing GELF message from %s: %v", addr, err)
 				return
 			}
@@ -291,6 +458,152 @@ func StartGELFUDPServer(addr string, ingestor *LogIngestor) error {
 			if err := ingestor.ProcessGELF(gelfMsg); err != nil {
 				log.Printf("Error processing GELF from %s: %v", addr, err)
 			}
-		}(buffer[:n], remoteAddr)
+		})
+	}
+}
+
+// GELF UDP chunking: a chunk starts with the magic bytes 0x1e 0x0f, an 8-byte
+// message ID, a sequence number and a sequence count. Incomplete messages are
+// capped in number and total size so unauthenticated senders can't grow the
+// reassembly buffer without bound.
+const (
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+	gelfChunkTimeout    = 5 * time.Second
+	gelfMaxPending      = 1024
+	gelfMaxPendingBytes = 64 << 20
+)
+
+// gelfPartial is a chunked message still being reassembled
+type gelfPartial struct {
+	chunks   [][]byte
+	received int
+	started  time.Time
+}
+
+// gelfChunkAssembler reassembles chunked GELF UDP messages. Chunks may arrive
+// out of order or more than once; messages not completed within the timeout
+// are dropped.
+type gelfChunkAssembler struct {
+	mu        sync.Mutex
+	partials  map[[8]byte]*gelfPartial
+	bytes     int // chunk data held by partials
+	timeout   time.Duration
+	lastSweep time.Time
+}
+
+func newGELFChunkAssembler(timeout time.Duration) *gelfChunkAssembler {
+	return &gelfChunkAssembler{
+		partials:  make(map[[8]byte]*gelfPartial),
+		timeout:   timeout,
+		lastSweep: time.Now(),
+	}
+}
+
+// Add takes a datagram and returns the complete message payload once all of
+// its chunks have arrived. Unchunked datagrams are returned as-is.
+func (a *gelfChunkAssembler) Add(datagram []byte) ([]byte, bool, error) {
+	if len(datagram) < 2 || datagram[0] != 0x1e || datagram[1] != 0x0f {
+		return datagram, true, nil
+	}
+	if len(datagram) < gelfChunkHeaderSize {
+		return nil, false, fmt.Errorf("chunk shorter than header (%d bytes)", len(datagram))
+	}
+
+	var id [8]byte
+	copy(id[:], datagram[2:10])
+	seq, count := int(datagram[10]), int(datagram[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return nil, false, fmt.Errorf("invalid chunk %d of %d", seq, count)
+	}
+	data := datagram[gelfChunkHeaderSize:]
+	if count == 1 {
+		return data, true, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	a.sweep(now)
+
+	partial, ok := a.partials[id]
+	if !ok && len(a.partials) >= gelfMaxPending {
+		return nil, false, fmt.Errorf("too many incomplete chunked messages (%d)", len(a.partials))
+	}
+	if a.bytes+len(data) > gelfMaxPendingBytes {
+		return nil, false, fmt.Errorf("incomplete chunked messages exceed %d bytes", gelfMaxPendingBytes)
+	}
+	if !ok {
+		partial = &gelfPartial{chunks: make([][]byte, count), started: now}
+		a.partials[id] = partial
+	}
+	if len(partial.chunks) != count {
+		return nil, false, fmt.Errorf("chunk count changed from %d to %d", len(partial.chunks), count)
+	}
+	if partial.chunks[seq] != nil {
+		return nil, false, nil // duplicate chunk
+	}
+	partial.chunks[seq] = data
+	partial.received++
+	a.bytes += len(data)
+
+	if partial.received < count {
+		return nil, false, nil
+	}
+
+	a.remove(id, partial)
+	return bytes.Join(partial.chunks, nil), true, nil
+}
+
+// remove forgets a partial message and releases its buffered bytes
+func (a *gelfChunkAssembler) remove(id [8]byte, partial *gelfPartial) {
+	for _, chunk := range partial.chunks {
+		a.bytes -= len(chunk)
+	}
+	delete(a.partials, id)
+}
+
+// sweep drops messages that have waited longer than the timeout for chunks
+func (a *gelfChunkAssembler) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < time.Second {
+		return
+	}
+	a.lastSweep = now
+
+	for id, partial := range a.partials {
+		if now.Sub(partial.started) > a.timeout {
+			log.Printf("Dropping incomplete GELF message (%d of %d chunks)", partial.received, len(partial.chunks))
+			a.remove(id, partial)
+		}
+	}
+}
+
+// decompressGELF detects gzip or zlib payloads by their magic bytes and
+// decompresses them; anything else is returned unchanged. Output is capped at
+// maxLineBytes so a small datagram can't expand without bound.
+func decompressGELF(data []byte) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	case len(data) >= 2 && data[0] == 0x78:
+		reader, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	payload, err := io.ReadAll(io.LimitReader(reader, maxLineBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxLineBytes {
+		return nil, fmt.Errorf("decompressed message exceeds %d bytes", maxLineBytes)
 	}
+	return payload, nil
 }
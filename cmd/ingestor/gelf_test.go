@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readGELFConnection feeds data through handleGELFConnection and returns the
+// short messages it queued once the sender closes the connection
+func readGELFConnection(t *testing.T, data string) []string {
+	t.Helper()
+
+	// No worker, so queued messages stay put
+	queue := &GELFQueue{messages: make(chan GELFMessage, 16)}
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handleGELFConnection(server, queue)
+		close(done)
+	}()
+
+	if _, err := client.Write([]byte(data)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	client.Close()
+	<-done
+
+	var messages []string
+	for len(queue.messages) > 0 {
+		messages = append(messages, (<-queue.messages).ShortMessage)
+	}
+	return messages
+}
+
+func TestGELFTCPConnectionClose(t *testing.T) {
+	first := `{"version":"1.1","host":"web-1","short_message":"first"}`
+	last := `{"version":"1.1","host":"web-1","short_message":"last"}`
+
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"terminated", first + "\x00" + last + "\x00", []string{"first", "last"}},
+		{"final message without null", first + "\x00" + last, []string{"first", "last"}},
+		{"only message without null", last, []string{"last"}},
+		{"trailing whitespace", first + "\x00\n ", []string{"first"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readGELFConnection(t, tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queued %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLevelFromMessageLastWins(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{`time=now level=debug msg="retrying" level=warning`, "warn"},
+		{`level=info level=error`, "error"},
+		{`{"level":"info","level":"error"}`, "error"},
+	}
+	for _, tt := range tests {
+		if got := parseLevelFromMessage(tt.message); got != tt.want {
+			t.Errorf("parseLevelFromMessage(%s) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+// gelfChunks splits payload into count chunked GELF datagrams
+func gelfChunks(id byte, payload []byte, count int) [][]byte {
+	size := (len(payload) + count - 1) / count
+	var chunks [][]byte
+	for seq := 0; seq < count; seq++ {
+		start, end := min(seq*size, len(payload)), min((seq+1)*size, len(payload))
+		header := []byte{0x1e, 0x0f, id, 0, 0, 0, 0, 0, 0, 0, byte(seq), byte(count)}
+		chunks = append(chunks, append(header, payload[start:end]...))
+	}
+	return chunks
+}
+
+func TestGELFChunkReassembly(t *testing.T) {
+	payload := []byte(`{"version":"1.1","host":"web-1","short_message":"chunked message body"}`)
+	chunks := gelfChunks(1, payload, 3)
+	assembler := newGELFChunkAssembler(time.Minute)
+
+	// Out of order, with a repeated chunk
+	for _, chunk := range [][]byte{chunks[2], chunks[0], chunks[2]} {
+		if _, complete, err := assembler.Add(chunk); complete || err != nil {
+			t.Fatalf("incomplete message: complete %v, err %v", complete, err)
+		}
+	}
+	got, complete, err := assembler.Add(chunks[1])
+	if err != nil || !complete || !bytes.Equal(got, payload) {
+		t.Fatalf("Add = %q, %v, %v; want the whole payload", got, complete, err)
+	}
+	if len(assembler.partials) != 0 {
+		t.Errorf("%d partial messages left after completion", len(assembler.partials))
+	}
+
+	// Unchunked datagrams and single-chunk messages pass straight through
+	if got, complete, err := assembler.Add(payload); !complete || err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("unchunked: got %q, %v, %v", got, complete, err)
+	}
+	if got, complete, err := assembler.Add(gelfChunks(2, payload, 1)[0]); !complete || err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("single chunk: got %q, %v, %v", got, complete, err)
+	}
+}
+
+func TestGELFChunkInvalid(t *testing.T) {
+	assembler := newGELFChunkAssembler(time.Minute)
+	tests := map[string][]byte{
+		"short header":      {0x1e, 0x0f, 1, 2, 3},
+		"sequence too high": {0x1e, 0x0f, 1, 0, 0, 0, 0, 0, 0, 0, 3, 3},
+		"zero count":        {0x1e, 0x0f, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		"too many chunks":   {0x1e, 0x0f, 1, 0, 0, 0, 0, 0, 0, 0, 0, gelfMaxChunks + 1},
+	}
+	for name, datagram := range tests {
+		if _, complete, err := assembler.Add(datagram); err == nil || complete {
+			t.Errorf("%s: want an error, got complete %v, err %v", name, complete, err)
+		}
+	}
+}
+
+func TestGELFChunkTimeout(t *testing.T) {
+	payload := []byte(`{"short_message":"never completed in time"}`)
+	chunks := gelfChunks(1, payload, 2)
+	assembler := newGELFChunkAssembler(time.Minute)
+
+	if _, complete, _ := assembler.Add(chunks[0]); complete {
+		t.Fatal("first chunk completed the message")
+	}
+
+	// Age the partial message past the timeout; the next chunk sweeps it
+	assembler.partials[[8]byte{1}].started = time.Now().Add(-2 * time.Minute)
+	assembler.lastSweep = time.Time{}
+	assembler.Add(gelfChunks(2, payload, 2)[0])
+
+	if _, complete, _ := assembler.Add(chunks[1]); complete {
+		t.Error("an expired message was completed by a late chunk")
+	}
+}
+
+func TestDecompressGELF(t *testing.T) {
+	payload := []byte(`{"short_message":"compressed"}`)
+
+	var gzipped, zlibbed bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(payload)
+	gz.Close()
+	zw := zlib.NewWriter(&zlibbed)
+	zw.Write(payload)
+	zw.Close()
+
+	for name, data := range map[string][]byte{"gzip": gzipped.Bytes(), "zlib": zlibbed.Bytes(), "plain": payload} {
+		got, err := decompressGELF(data)
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Errorf("%s: got %q, %v", name, got, err)
+		}
+	}
+}
+
+func TestGELFChunkPendingLimits(t *testing.T) {
+	assembler := newGELFChunkAssembler(time.Minute)
+	chunk := func(id int, seq byte, data []byte) []byte {
+		header := []byte{0x1e, 0x0f, byte(id), byte(id >> 8), 0, 0, 0, 0, 0, 0, seq, 2}
+		return append(header, data...)
+	}
+
+	for id := 0; id < gelfMaxPending; id++ {
+		if _, _, err := assembler.Add(chunk(id, 0, []byte("a"))); err != nil {
+			t.Fatalf("message %d: %v", id, err)
+		}
+	}
+	if _, _, err := assembler.Add(chunk(gelfMaxPending, 0, []byte("a"))); err == nil {
+		t.Error("a new message past gelfMaxPending should be refused")
+	}
+
+	// Messages already pending can still complete, freeing their slot
+	if got, complete, err := assembler.Add(chunk(0, 1, []byte("b"))); !complete || err != nil || string(got) != "ab" {
+		t.Errorf("completing a pending message: got %q, %v, %v", got, complete, err)
+	}
+	if _, _, err := assembler.Add(chunk(gelfMaxPending, 0, []byte("a"))); err != nil {
+		t.Errorf("a freed slot should take a new message: %v", err)
+	}
+	if assembler.bytes != gelfMaxPending {
+		t.Errorf("buffered %d bytes, want %d", assembler.bytes, gelfMaxPending)
+	}
+
+	// The total size of buffered chunks is capped too
+	assembler = newGELFChunkAssembler(time.Minute)
+	large := make([]byte, gelfMaxPendingBytes/4)
+	for id := 0; id < 4; id++ {
+		if _, _, err := assembler.Add(chunk(id, 0, large)); err != nil {
+			t.Fatalf("large message %d: %v", id, err)
+		}
+	}
+	if _, _, err := assembler.Add(chunk(4, 0, []byte("a"))); err == nil {
+		t.Errorf("buffering past gelfMaxPendingBytes should be refused")
+	}
+}
+
+func TestDecompressGELFLimit(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(make([]byte, maxLineBytes+1))
+	gz.Close()
+
+	if payload, err := decompressGELF(compressed.Bytes()); err == nil {
+		t.Errorf("a %d byte gzip datagram expanded to %d bytes without error", compressed.Len(), len(payload))
+	}
+}
+
+func TestParseLevelFromMessageOwnKey(t *testing.T) {
+	// GELF reads its own "level" key, whatever -level-fields says
+	setLiveFlag(t, "level-fields", "severity")
+
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{`{"level":"warning","severity":"error"}`, "warn"},
+		{`{"severity":"error"}`, ""},
+		{`{"msg":"handled","detail":"level=debug"}`, "debug"}, // falls back to logrus text
+		{`{"level":"verbose"} level=info`, "info"},            // not JSON
+		{`level=notice`, ""},
+	}
+	for _, tt := range tests {
+		if got := parseLevelFromMessage(tt.message); got != tt.want {
+			t.Errorf("parseLevelFromMessage(%s) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// writeSuccessMarkers writes an empty _SUCCESS object into every directory
+// a flush wrote to, so Spark/Hadoop readers treat those partitions as
+// complete. Directories with a spilled file are not complete and get no
+// marker. Like manifests, failures are only logged.
+func writeSuccessMarkers(storage Storage, files []ManifestFile) {
+	complete := make(map[string]bool)
+	for _, file := range files {
+		dir := path.Dir(file.Key)
+		if _, seen := complete[dir]; !seen {
+			complete[dir] = true
+		}
+		if file.Spilled {
+			complete[dir] = false
+		}
+	}
+
+	for dir, ok := range complete {
+		if !ok {
+			continue
+		}
+		key := dir + "/_SUCCESS"
+		if err := storage.Put(context.TODO(), key, nil); err != nil {
+			log.Printf("Error writing %s (data files were written): %v", key, err)
+		}
+	}
+}
+
+// athenaType maps a parquet column to its Athena/Hive type
+func athenaType(node parquet.Node) string {
+	if logical := node.Type().LogicalType(); logical != nil {
+		switch {
+		case logical.Timestamp != nil:
+			return "timestamp"
+		case logical.UTF8 != nil:
+			return "string"
+		case logical.Integer != nil && logical.Integer.BitWidth <= 32:
+			return "int"
+		case logical.Integer != nil:
+			return "bigint"
+		}
+	}
+
+	switch node.Type().Kind() {
+	case parquet.Boolean:
+		return "boolean"
+	case parquet.Int32:
+		return "int"
+	case parquet.Int64:
+		return "bigint"
+	case parquet.Float:
+		return "float"
+	case parquet.Double:
+		return "double"
+	default:
+		return "string"
+	}
+}
+
+// athenaCompression names -compression the way parquet.compression expects
+func athenaCompression(name string) string {
+	switch name = strings.ToLower(strings.TrimSpace(name)); name {
+	case "none":
+		return "UNCOMPRESSED"
+	case "lz4":
+		return "LZ4_RAW"
+	default:
+		return strings.ToUpper(name)
+	}
+}
+
+// ddlLocation returns the S3 location of the ingested files. Athena and Glue
+// only read from S3, so there is none without an s3 backend.
+func ddlLocation() (string, bool) {
+	for _, name := range backendNames() {
+		if name == "s3" {
+			return fmt.Sprintf("s3://%s/%s/", *bucket, strings.Trim(*prefix, "/")), true
+		}
+	}
+	return "", false
+}
+
+// buildDDL renders a CREATE EXTERNAL TABLE statement for the files this
+// ingestor writes: the LogEntry columns plus any -extract-fields, partitioned
+// by the -partition-by dimensions
+func buildDDL(table, location string) string {
+	schema := extractSchema
+	if schema == nil {
+		schema = parquet.SchemaOf(LogEntry{})
+	}
+
+	isPartition := make(map[string]bool)
+	for _, dim := range partitionDims {
+		isPartition[dim] = true
+	}
+
+	// Hive forbids a data column sharing a partition key's name. The
+	// partition holds the same value, so the data column is left out.
+	var columns, omitted []string
+	for _, field := range schema.Fields() {
+		if isPartition[field.Name()] {
+			omitted = append(omitted, field.Name())
+			continue
+		}
+		columns = append(columns, fmt.Sprintf("  `%s` %s", field.Name(), athenaType(field)))
+	}
+	var partitions []string
+	for _, dim := range partitionDims {
+		partitions = append(partitions, fmt.Sprintf("  `%s` string", dim))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Generated by the blobsearch ingestor (-emit-ddl); reflects -compression,\n")
+	fmt.Fprintf(&b, "-- -partition-by and -extract-fields at startup.\n")
+	if len(partitions) > 0 {
+		fmt.Fprintf(&b, "-- Entries missing a partition value and -combine-small-partitions files sit outside\n")
+		fmt.Fprintf(&b, "-- the full partition path and are not visible through this table.\n")
+		fmt.Fprintf(&b, "-- Run MSCK REPAIR TABLE `%s` after new partitions appear.\n", table)
+	}
+	if len(omitted) > 0 {
+		fmt.Fprintf(&b, "-- Data columns %s are read from the partition of the same name.\n", strings.Join(omitted, ", "))
+	}
+	fmt.Fprintf(&b, "CREATE EXTERNAL TABLE IF NOT EXISTS `%s` (\n%s\n)\n", table, strings.Join(columns, ",\n"))
+	if len(partitions) > 0 {
+		fmt.Fprintf(&b, "PARTITIONED BY (\n%s\n)\n", strings.Join(partitions, ",\n"))
+	}
+	fmt.Fprintf(&b, "STORED AS PARQUET\n")
+	fmt.Fprintf(&b, "LOCATION '%s'\n", location)
+	fmt.Fprintf(&b, "TBLPROPERTIES ('parquet.compression'='%s');\n", athenaCompression(*compression))
+	return b.String()
+}
+
+// writeDDL stores the table DDL as <prefix>/_schema/<table>.sql. The table
+// is named after the prefix. Without an s3 backend it only logs a warning.
+func writeDDL(storage Storage) error {
+	location, ok := ddlLocation()
+	if !ok {
+		log.Printf("Skipping -emit-ddl: Athena/Glue tables read from S3, but the backend is %s", strings.Join(backendNames(), ","))
+		return nil
+	}
+
+	table := strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.Trim(*prefix, "/"))
+	if table == "" {
+		table = "logs"
+	}
+
+	ddl := buildDDL(table, location)
+	key := fmt.Sprintf("%s/_schema/%s.sql", *prefix, table)
+	if err := storage.Put(context.TODO(), key, []byte(ddl)); err != nil {
+		return err
+	}
+	log.Printf("Wrote table DDL to %s/%s", storage.Name(), key)
+	return nil
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+// ddlSections splits a DDL statement into its column and partition lists
+func ddlSections(t *testing.T, ddl string) (columns, partitions string) {
+	t.Helper()
+	_, rest, ok := strings.Cut(ddl, "CREATE EXTERNAL TABLE")
+	if !ok {
+		t.Fatalf("no CREATE EXTERNAL TABLE in:\n%s", ddl)
+	}
+	columns, partitions, _ = strings.Cut(rest, "PARTITIONED BY")
+	return columns, partitions
+}
+
+func TestBuildDDLDefaultFlags(t *testing.T) {
+	defer func(dims []string) { partitionDims = dims }(partitionDims)
+
+	dims, err := parsePartitionBy(flag.Lookup("partition-by").DefValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitionDims = dims
+
+	ddl := buildDDL("logs", "s3://bucket/logs/")
+	columns, partitions := ddlSections(t, ddl)
+
+	for _, column := range []string{"`timestamp` timestamp", "`message` string", "`line_number` bigint"} {
+		if !strings.Contains(columns, column) {
+			t.Errorf("missing column %s in:\n%s", column, ddl)
+		}
+	}
+	for _, dim := range []string{"date", "level"} {
+		if strings.Contains(columns, "`"+dim+"`") {
+			t.Errorf("%s is declared both as a data column and a partition:\n%s", dim, ddl)
+		}
+		if !strings.Contains(partitions, "`"+dim+"` string") {
+			t.Errorf("missing partition %s in:\n%s", dim, ddl)
+		}
+	}
+	if !strings.Contains(ddl, "-- Data columns level, date are read from the partition") {
+		t.Errorf("the omitted columns should be noted:\n%s", ddl)
+	}
+}
+
+func TestBuildDDLOtherDimensions(t *testing.T) {
+	defer func(dims []string) { partitionDims = dims }(partitionDims)
+	partitionDims = []string{"hour", "service"}
+
+	ddl := buildDDL("logs", "s3://bucket/logs/")
+	columns, partitions := ddlSections(t, ddl)
+	if !strings.Contains(columns, "`level` string") || !strings.Contains(columns, "`date` string") {
+		t.Errorf("level and date should stay data columns:\n%s", ddl)
+	}
+	if !strings.Contains(partitions, "`hour` string,\n  `service` string") {
+		t.Errorf("partitions should follow -partition-by order:\n%s", ddl)
+	}
+	if strings.Contains(columns, "`hour`") || strings.Contains(columns, "`service`") {
+		t.Errorf("the -partition-as-column hour and service columns should be left out:\n%s", ddl)
+	}
+}
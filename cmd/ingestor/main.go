@@ -10,52 +10,128 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	kzstd "github.com/klauspost/compress/zstd"
 	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+
+	"blobsearch/internal/logstore"
 )
 
 var (
+	configFile        = flag.String("config", "", "File of name = value flag settings; extraction settings are reloaded on SIGHUP")
 	bucket            = flag.String("bucket", "", "S3 bucket name or local directory")
 	prefix            = flag.String("prefix", "logs", "S3 prefix for log files")
-	batchSize         = flag.Int("batch-size", 10000, "Number of log entries per parquet file")
-	compression       = flag.String("compression", "snappy", "Compression algorithm (snappy, gzip, none)")
-	localFile         = flag.Bool("local", false, "Write to local files instead of S3")
+	batchSize         = flag.Int("batch-size", 10000, "Number of log entries per parquet file (0 flushes by -batch-bytes only)")
+	compression       = flag.String("compression", "snappy", "Compression algorithm (snappy, gzip, zstd, lz4, none)")
+	compressionLevel  = flag.Int("compression-level", 0, "zstd compression level 1-22 (0 uses the codec default)")
+	partitionBy       = flag.String("partition-by", "date,level", "Ordered, comma-separated partition dimensions: date, hour, level, service")
+	partitionTimeSrc  = flag.String("partition-time-source", "event", "Time used for the date= partition: event (parsed timestamp) or ingest (arrival time)")
+	partitionAsColumn = flag.Bool("partition-as-column", false, "Also write the -partition-by values (date, hour, service) as columns inside each file; level is always a column")
+	combineSmall      = flag.Bool("combine-small-partitions", false, "Pack partitions smaller than -min-file-bytes from the same batch into one file with a partition column")
+	minFileBytes      = flag.Int64("min-file-bytes", 1<<20, "Encoded size below which a partition counts as small for -combine-small-partitions")
+	batchBytes        = flag.Int64("batch-bytes", 0, "Flush a batch once its estimated uncompressed size reaches this many bytes (0 disables)")
+	targetFileBytes   = flag.Int64("target-file-bytes", 0, "Split partition files whose encoded size exceeds this many bytes into _partNN files (0 disables)")
+	fileDateLayout    = flag.String("filename-date-layout", "2006-01-02", "Go time layout for the date component of parquet file names")
+	fileHourLayout    = flag.String("filename-hour-layout", "15", "Go time layout for the hour component of parquet file names")
+	bloomFilter       = flag.Bool("bloom-filter", false, "Write a bloom filter on the content_hash column so files can be skipped in hash lookups")
+	bloomBits         = flag.Uint("bloom-bits-per-value", 10, "Bloom filter size in bits per row (10 gives about a 1% false positive rate)")
+	pageSize          = flag.Int("page-size", 0, "Parquet page buffer size in bytes (0 uses the library default of 256KiB)")
+	localFile         = flag.Bool("local", false, "Write to local files instead of S3 (shorthand for -backend local)")
+	backend           = flag.String("backend", "", "Comma-separated storage backends to write every flush to (s3, local)")
+	backendQuorum     = flag.Int("backend-quorum", 0, "Backends that must accept a write for a flush to succeed (0 requires all)")
+	breakerThreshold  = flag.Int("breaker-threshold", 5, "Consecutive storage write failures that open the circuit breaker (0 disables)")
+	breakerCooldown   = flag.Duration("breaker-cooldown", 30*time.Second, "How long the circuit breaker stays open before probing the backend")
+	uploadRetries     = flag.Int("upload-retries", 3, "Retries for a failed storage write, with exponential backoff (0 disables)")
+	uploadRetryDelay  = flag.Duration("upload-retry-delay", 500*time.Millisecond, "Delay before the first storage write retry; doubled on each further retry")
+	spillDir          = flag.String("spill-dir", "", "Directory to write parquet files to, under their intended key, when storage writes fail after retries")
+	drainSpill        = flag.Bool("drain-spill", false, "Upload files left in -spill-dir at startup, removing them once written")
+	selfTest          = flag.Bool("self-test", false, "Write and read back a probe file at startup, exiting if storage is unusable")
+	localDir          = flag.String("local-dir", "", "Directory for the local backend (defaults to -bucket)")
 	logTimestamps     = flag.Bool("with-timestamps", false, "Parse and include timestamps from logs")
 	endpoint          = flag.String("endpoint", "", "Custom S3 endpoint (for MinIO/local S3)")
 	accessKey         = flag.String("access-key", "", "AWS access key (for custom endpoint)")
 	secretKey         = flag.String("secret-key", "", "AWS secret key (for custom endpoint)")
 	region            = flag.String("region", "us-east-1", "AWS region")
+	successMarkers    = flag.Bool("success-markers", false, "Write an empty _SUCCESS object into each partition directory after a flush writes to it")
+	emitDDL           = flag.Bool("emit-ddl", false, "Write an Athena/Glue CREATE EXTERNAL TABLE statement for the configured schema to <prefix>/_schema/ at startup")
+	s3SSE             = flag.String("s3-sse", "", "Server-side encryption for S3 objects: aes256 or aws:kms")
+	s3KMSKeyID        = flag.String("s3-kms-key-id", "", "KMS key ID or ARN for -s3-sse aws:kms (defaults to the bucket's AWS managed key)")
+	s3Tags            = flag.String("s3-tags", "", "Comma-separated key=value tags applied to every S3 object")
+	inputFile         = flag.String("input", "", "Ingest a log file instead of stdin (backfill)")
+	defaultTime       = flag.String("default-time", "", "With -input, timestamp for lines without one (RFC3339 or 2006-01-02; defaults to the file's modification time)")
+	replayRate        = flag.String("replay-rate", "", "With -input, pace ingestion to lines/sec (500 or 500l) or bytes/sec (e.g. 2mb)")
+	shardCount        = flag.Int("shards", 4, "Number of independently locked and flushed batch buffers; partitions are spread across them and each holds up to -batch-size entries")
+	maxBatches        = flag.Int("max-batches", 0, "Stop accepting logs after this many batches have been flushed (0 means unlimited)")
 	httpMode          = flag.Bool("http", false, "Run as HTTP server")
 	httpPort          = flag.String("port", "8080", "HTTP server port")
 	deduplicate       = flag.Bool("deduplicate", false, "Enable deduplication (keeps only unique logs)")
 	dedupWindow       = flag.Int("dedup-window", 100000, "Number of recent hashes to keep for deduplication")
+	dedupScope        = flag.String("dedup-scope", "global", "Deduplication scope: global, or partition to only suppress repeats within the same partition")
+	dedupKey          = flag.String("dedup-key", "message+timestamp", "What identifies a duplicate: message, or message+timestamp")
+	dedupHashBits     = flag.Int("dedup-hash-bits", 64, "Bits of the SHA-256 content hash kept for dedup and the content_hash column (multiple of 4, up to 256)")
 	autoFlush         = flag.Bool("auto-flush", true, "Enable automatic periodic flushing")
 	autoFlushInterval = flag.Int("auto-flush-interval", 90, "Auto-flush interval in seconds")
-	timestampFields   = flag.String("timestamp-fields", "timestamp,time,@timestamp", "Comma-separated JSON field names to check for timestamp")
-	levelFields       = flag.String("level-fields", "level,severity,severityText", "Comma-separated JSON field names to check for log level")
+	timestampFields   = flag.String("timestamp-fields", "timestamp,time,@timestamp", "Comma-separated JSON field paths to check for timestamp (dots reach nested fields)")
+	levelFields       = flag.String("level-fields", "level,severity,severityText", "Comma-separated JSON field paths to check for log level (dots reach nested fields)")
+	docIDMode         = flag.String("doc-id-mode", "none", "Stable document ID column: none, uuidv7 (time-ordered), or hash (full content SHA-256)")
+	recordSeparator   = flag.String("record-separator", "newline", "Record separator for stdin and /ingest input: newline, rs (RFC 7464 json-seq), or null")
+	dropFields        = flag.String("drop-fields", "", "Comma-separated JSON field paths to remove from JSON logs before storage")
+	bodyAsSingle      = flag.Bool("body-as-single-entry", false, "Treat each /ingest request body as a single log entry instead of splitting lines")
+	gelfQueueSize     = flag.Int("gelf-queue-size", 10000, "Maximum GELF TCP messages buffered between reading and processing")
+	gelfWorkers       = flag.Int("gelf-workers", runtime.NumCPU(), "Goroutines processing queued GELF TCP messages")
+	shutdownTimeout   = flag.Duration("shutdown-timeout", 30*time.Second, "Maximum time to drain requests and flush buffered entries on SIGINT/SIGTERM (HTTP mode)")
+	gelfTCP           = flag.Bool("gelf-tcp", true, "Enable the GELF TCP server (HTTP mode)")
+	gelfTCPAddr       = flag.String("gelf-tcp-addr", ":12201", "Bind address for the GELF TCP server")
+	gelfTLS           = flag.Bool("gelf-tls", false, "Serve GELF TCP over TLS using -tls-cert and -tls-key")
+	tlsCert           = flag.String("tls-cert", "", "TLS certificate file; with -tls-key, serves HTTP over HTTPS")
+	tlsKey            = flag.String("tls-key", "", "TLS private key file")
+	authToken         = flag.String("auth-token", "", "Require Authorization: Bearer <token> on /ingest, /gelf, /v1/logs, /flush and /stats (GELF TCP/UDP and syslog stay unauthenticated)")
+	clientCA          = flag.String("client-ca", "", "CA bundle for verifying client certificates; requires mutual TLS on the ingest endpoints and GELF TLS")
+	gelfUDP           = flag.Bool("gelf-udp", false, "Enable the GELF UDP server (HTTP mode)")
+	gelfUDPAddr       = flag.String("gelf-udp-addr", ":12201", "Bind address for the GELF UDP server")
+	syslogEnabled     = flag.Bool("syslog", false, "Enable the syslog (RFC5424/RFC3164) TCP and UDP servers (HTTP mode)")
+	syslogAddr        = flag.String("syslog-addr", ":514", "Bind address for the syslog TCP and UDP servers")
+	gelfLevelMapSpec  = flag.String("gelf-level-map", "", "Comma-separated number=level mappings for non-standard GELF levels (e.g. 10=debug,20=info,30=warn,40=error)")
+	extractFieldSpec  = flag.String("extract-fields", "", "Comma-separated path:type mappings (type string, int, float or bool) promoted into nullable columns, e.g. traceId:string,attributes.http.status_code:int")
+	captureExceptions = flag.Bool("capture-exceptions", false, "Populate exception_type, exception_message and stack_trace columns from -exception-fields")
+	exceptionFields   = flag.String("exception-fields", "type=attributes.exception.type|attributes.error.type|exception.type|error.type,message=attributes.exception.message|exception.message,stacktrace=attributes.exception.stacktrace|exception.stacktrace", "Column=path|path mappings for -capture-exceptions")
+	trackDimensions   = flag.Bool("track-dimensions", false, "Track recently seen services and hosts for the /dimensions endpoint")
+	dimensionTTL      = flag.Duration("dimension-ttl", time.Hour, "Forget dimension values not seen within this duration")
+	dimensionMax      = flag.Int("dimension-max-values", 1000, "Maximum distinct values tracked per dimension")
+	serviceFields     = flag.String("service-fields", "resource.service.name,service.name,service", "Comma-separated JSON field paths to check for the service name")
+	hostFields        = flag.String("host-fields", "host,hostname,resource.host.name", "Comma-separated JSON field paths to check for the host name")
+	cardinalityFields = flag.String("cardinality-fields", "", "Comma-separated JSON fields to estimate distinct counts for (HyperLogLog), reported in /stats")
+	forwardURL        = flag.String("forward-url", "", "Also POST accepted lines (newline-delimited batches) to this downstream HTTP endpoint")
+	forwardBatch      = flag.Int("forward-batch-size", 500, "Maximum lines per forwarded request")
+	forwardInterval   = flag.Duration("forward-interval", time.Second, "Maximum time lines wait before being forwarded")
+	forwardQueue      = flag.Int("forward-queue-size", 10000, "Lines buffered for forwarding before new lines are dropped")
+	inferLevel        = flag.Bool("infer-level-keywords", false, "Infer log level from message keywords when no structured level field is found")
+	levelKeywords     = flag.String("level-keywords", "error:error|fatal|panic|exception|critical,warn:warn|warning,debug:debug|trace,info:info", "Ordered level:keyword|keyword rules used by -infer-level-keywords")
 )
 
 // LogEntry represents a log entry that will be written to Parquet
-type LogEntry struct {
-	Timestamp   time.Time `parquet:"timestamp"`
-	Message     string    `parquet:"message"`
-	Level       string    `parquet:"level"`
-	LineNumber  int64     `parquet:"line_number"`
-	ContentHash string    `parquet:"content_hash"`
-}
+type LogEntry = logstore.LogEntry
 
 // BatchInfo tracks information about the current batch
 type BatchInfo struct {
@@ -64,6 +140,9 @@ type BatchInfo struct {
 	EndTime     time.Time
 	LineNumber  int64
 	BatchNumber int
+	Bytes       int64 // estimated uncompressed size of Entries
+
+	acks map[string]map[*durableAck]bool // durable requests with entries in each partition group
 }
 
 // PartitionTracker manages partition information for efficient querying
@@ -72,16 +151,59 @@ type PartitionTracker struct {
 	partitionMap map[string]int
 }
 
-// GetPartitionKey returns the partition key for a log entry
+// partitionTime returns the time used for time-based partition segments, in
+// local time so date= and hour= don't depend on the offset a timestamp was
+// logged in
+func partitionTime(entry LogEntry) time.Time {
+	if *partitionTimeSrc == "ingest" && !entry.IngestTime.IsZero() {
+		return entry.IngestTime.Local()
+	}
+	return entry.Timestamp.Local()
+}
+
+// partitionDims is the parsed -partition-by list
+var partitionDims = []string{"date", "level"}
+
+// parsePartitionBy validates a -partition-by list
+func parsePartitionBy(spec string) ([]string, error) {
+	var dims []string
+	seen := make(map[string]bool)
+	for _, dim := range strings.Split(spec, ",") {
+		dim = strings.TrimSpace(dim)
+		switch dim {
+		case "date", "hour", "level", "service":
+		case "":
+			continue
+		default:
+			return nil, fmt.Errorf("unknown dimension %q (expected date, hour, level or service)", dim)
+		}
+		if seen[dim] {
+			return nil, fmt.Errorf("dimension %q listed twice", dim)
+		}
+		seen[dim] = true
+		dims = append(dims, dim)
+	}
+	return dims, nil
+}
+
+// partitionsByService reports whether entries need their service name extracted
+func partitionsByService() bool {
+	for _, dim := range partitionDims {
+		if dim == "service" {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPartitionKey returns the partition key for a log entry, built from the
+// -partition-by dimensions. Empty values (and the unknown level) are skipped.
 func GetPartitionKey(entry LogEntry) string {
-	dateStr := entry.Timestamp.Format("2006-01-02")
-	level := entry.Level
 	var parts []string
-	if dateStr != "" {
-		parts = append(parts, fmt.Sprintf("date=%s", dateStr))
-	}
-	if level != "" && level != "unknown" {
-		parts = append(parts, fmt.Sprintf("level=%s", level))
+	for _, dim := range partitionDims {
+		if value := partitionValue(entry, dim); value != "" {
+			parts = append(parts, dim+"="+value)
+		}
 	}
 	if len(parts) > 0 {
 		return strings.Join(parts, "/")
@@ -89,6 +211,24 @@ func GetPartitionKey(entry LogEntry) string {
 	return ""
 }
 
+// partitionValue returns an entry's value for a partition dimension, or ""
+// when the entry has none
+func partitionValue(entry LogEntry, dim string) string {
+	switch dim {
+	case "date":
+		return partitionTime(entry).Format("2006-01-02")
+	case "hour":
+		return partitionTime(entry).Format("15")
+	case "level":
+		if entry.Level != "unknown" {
+			return entry.Level
+		}
+	case "service":
+		return strings.ReplaceAll(entry.Service, "/", "_")
+	}
+	return ""
+}
+
 // NewPartitionTracker creates a new partition tracker
 func NewPartitionTracker() *PartitionTracker {
 	return &PartitionTracker{
@@ -118,14 +258,15 @@ func (pt *PartitionTracker) GetPartitionCount() int {
 type DedupCache struct {
 	mu      sync.RWMutex
 	hashes  map[string]bool
-	order   []string
+	order   []string // ring buffer of hashes in insertion order
+	next    int      // ring slot the next hash is written to
 	maxSize int
 }
 
 func NewDedupCache(maxSize int) *DedupCache {
 	return &DedupCache{
 		hashes:  make(map[string]bool),
-		order:   make([]string, 0, maxSize),
+		order:   make([]string, max(maxSize, 0)),
 		maxSize: maxSize,
 	}
 }
@@ -144,17 +285,37 @@ func (dc *DedupCache) Add(hash string) {
 	if dc.hashes[hash] {
 		return
 	}
+	dc.addLocked(hash)
+}
+
+// addLocked inserts a hash not yet in the cache; dc.mu must be held
+func (dc *DedupCache) addLocked(hash string) {
+	if dc.maxSize <= 0 {
+		return
+	}
+
+	// If cache is full, the slot being reused holds the oldest entry
+	if len(dc.hashes) >= dc.maxSize {
+		delete(dc.hashes, dc.order[dc.next])
+	}
 
 	// Add to cache
 	dc.hashes[hash] = true
-	dc.order = append(dc.order, hash)
+	dc.order[dc.next] = hash
+	dc.next = (dc.next + 1) % dc.maxSize
+}
+
+// AddIfAbsent adds hash and reports whether it was new, as one atomic step so
+// concurrent identical lines can't both pass the duplicate check
+func (dc *DedupCache) AddIfAbsent(hash string) bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
 
-	// If cache is full, remove oldest entry
-	if len(dc.order) > dc.maxSize {
-		oldest := dc.order[0]
-		delete(dc.hashes, oldest)
-		dc.order = dc.order[1:]
+	if dc.hashes[hash] {
+		return false
 	}
+	dc.addLocked(hash)
+	return true
 }
 
 func (dc *DedupCache) Size() int {
@@ -163,145 +324,445 @@ func (dc *DedupCache) Size() int {
 	return len(dc.hashes)
 }
 
+// ErrMaxBatchesReached is returned once -max-batches batches have been flushed
+var ErrMaxBatchesReached = errors.New("max batches reached, no further ingestion accepted")
+
+// durableAck collects the keys of the objects holding one durable request's
+// entries, including files flushed by other requests or the size triggers
+type durableAck struct {
+	mu      sync.Mutex
+	seen    map[string]bool
+	keys    []string
+	spilled []string // saved to -spill-dir instead of storage
+}
+
+func newDurableAck() *durableAck {
+	return &durableAck{seen: make(map[string]bool), keys: []string{}}
+}
+
+// add records a file holding some of the request's entries
+func (a *durableAck) add(file ManifestFile) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.seen[file.Key] {
+		return
+	}
+	a.seen[file.Key] = true
+	if file.Spilled {
+		a.spilled = append(a.spilled, file.Key)
+	} else {
+		a.keys = append(a.keys, file.Key)
+	}
+}
+
+// batchShard buffers the entries of a subset of partitions. Shards lock and
+// flush independently so concurrent connections don't serialize on one batch.
+type batchShard struct {
+	mu    sync.Mutex
+	batch *BatchInfo
+
+	// After a failed flush, size-triggered flushes wait until retryAt so a
+	// storage outage isn't retried on every line while holding mu
+	failures int
+	retryAt  time.Time
+}
+
+// Backoff between size-triggered flush attempts after a failure
+const (
+	minFlushBackoff = time.Second
+	maxFlushBackoff = time.Minute
+)
+
+func newBatchInfo() *BatchInfo {
+	return &BatchInfo{
+		Entries:   make([]LogEntry, 0, *batchSize),
+		StartTime: time.Now(),
+		EndTime:   time.Now(),
+	}
+}
+
+// trackAck records that a durable request has entries in a partition group
+func (b *BatchInfo) trackAck(partitionKey string, ack *durableAck) {
+	if partitionKey == "" {
+		partitionKey = "unpartitioned"
+	}
+	if b.acks == nil {
+		b.acks = make(map[string]map[*durableAck]bool)
+	}
+	if b.acks[partitionKey] == nil {
+		b.acks[partitionKey] = make(map[*durableAck]bool)
+	}
+	b.acks[partitionKey][ack] = true
+}
+
+// entryFixedBytes approximates the fixed-width columns of a row (timestamp,
+// line number) plus per-value encoding overhead
+const entryFixedBytes = 32
+
+// estimatedSize approximates an entry's uncompressed size for -batch-bytes
+func estimatedSize(e *LogEntry) int64 {
+	return int64(entryFixedBytes + len(e.Message) + len(e.Level) + len(e.ContentHash) +
+		len(e.DocID) + len(e.Partition) + len(e.Date) +
+		len(e.ExceptionType) + len(e.ExceptionMessage) + len(e.StackTrace))
+}
+
 // LogIngestor handles log ingestion with buffering
 type LogIngestor struct {
 	partitionTracker *PartitionTracker
-	s3Client         *s3.Client
-	batch            *BatchInfo
-	batchNumber      int
-	lineCount        int64
+	dimensions       *DimensionTracker
+	cardinality      *CardinalityTracker
+	forwarder        *Forwarder
+	storage          Storage
+	shards           []*batchShard
+	batchNumber      atomic.Int64 // next batch number; numbers are assigned as batches flush
+	lineCount        atomic.Int64 // updated atomically so stats reads don't take a lock
 	dedupCache       *DedupCache
-	duplicateCount   int64
-	mu               sync.Mutex
+	duplicateCount   atomic.Int64
+	fallbackTime     func() time.Time
+	exhausted        atomic.Bool
+	startTime        time.Time
+	batchesFlushed   int
+	filesWritten     int
+	filesSpilled     int
+	bytesWritten     int64 // once per file, however many backends it reached
+	flushErrors      int
+	mu               sync.Mutex // guards the flush counters above
 	stopAutoFlush    chan struct{}
 	autoFlushStopped chan struct{}
 }
 
-func NewLogIngestor(s3Client *s3.Client) *LogIngestor {
+func NewLogIngestor(storage Storage) *LogIngestor {
 	var dedupCache *DedupCache
 	if *deduplicate {
 		dedupCache = NewDedupCache(*dedupWindow)
 		log.Printf("Deduplication enabled (window size: %d)", *dedupWindow)
 	}
 
+	var dimensions *DimensionTracker
+	if *trackDimensions {
+		dimensions = NewDimensionTracker(*dimensionTTL, *dimensionMax)
+	}
+
+	var cardinality *CardinalityTracker
+	if *cardinalityFields != "" {
+		cardinality = NewCardinalityTracker(*cardinalityFields)
+	}
+
+	var forwarder *Forwarder
+	if *forwardURL != "" {
+		forwarder = NewForwarder(*forwardURL, *forwardQueue, *forwardBatch, *forwardInterval)
+		log.Printf("Forwarding accepted lines to %s", *forwardURL)
+	}
+
 	li := &LogIngestor{
 		partitionTracker: NewPartitionTracker(),
-		s3Client:         s3Client,
-		batch: &BatchInfo{
-			Entries:     make([]LogEntry, 0, *batchSize),
-			StartTime:   time.Now(),
-			EndTime:     time.Now(),
-			BatchNumber: 0,
-		},
-		batchNumber:      0,
-		lineCount:        0,
+		dimensions:       dimensions,
+		cardinality:      cardinality,
+		forwarder:        forwarder,
+		fallbackTime:     time.Now,
+		startTime:        time.Now(),
+		storage:          storage,
 		dedupCache:       dedupCache,
-		duplicateCount:   0,
 		stopAutoFlush:    make(chan struct{}),
 		autoFlushStopped: make(chan struct{}),
 	}
 
+	for i := 0; i < *shardCount; i++ {
+		li.shards = append(li.shards, &batchShard{batch: newBatchInfo()})
+	}
+
 	// Start auto-flush goroutine if enabled
 	if *autoFlush {
 		log.Printf("Auto-flush enabled (interval: %d seconds)", *autoFlushInterval)
 		go li.autoFlushWorker()
 	}
 
+	if *configFile != "" {
+		go li.watchConfigReload(*configFile)
+	}
+
 	return li
 }
 
+// computeContentHash hashes the fields selected by -dedup-key, truncated to -dedup-hash-bits
 func (li *LogIngestor) computeContentHash(message string, timestamp time.Time) string {
 	h := sha256.New()
 	h.Write([]byte(message))
-	h.Write([]byte(timestamp.Format(time.RFC3339Nano)))
-	return fmt.Sprintf("%x", h.Sum(nil))[:16]
+	if *dedupKey == "message+timestamp" {
+		h.Write([]byte(timestamp.Format(time.RFC3339Nano)))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:*dedupHashBits/4]
+}
+
+// generateDocID returns the external document key for an entry according to -doc-id-mode
+func generateDocID(message string, timestamp time.Time) string {
+	switch *docIDMode {
+	case "uuidv7":
+		id, err := uuid.NewV7()
+		if err != nil {
+			return uuid.NewString()
+		}
+		return id.String()
+	case "hash":
+		h := sha256.New()
+		h.Write([]byte(message))
+		h.Write([]byte(timestamp.Format(time.RFC3339Nano)))
+		return fmt.Sprintf("%x", h.Sum(nil))
+	default:
+		return ""
+	}
 }
 
 func (li *LogIngestor) ProcessLine(line string) error {
-	li.mu.Lock()
-	defer li.mu.Unlock()
+	return li.processLine(line, nil)
+}
+
+// ProcessDurableLine processes a line and records the files it is flushed to in ack
+func (li *LogIngestor) ProcessDurableLine(line string, ack *durableAck) error {
+	return li.processLine(line, ack)
+}
+
+func (li *LogIngestor) processLine(line string, ack *durableAck) error {
+	if li.exhausted.Load() {
+		return ErrMaxBatchesReached
+	}
+
+	// Parse outside any batch lock so lines are processed in parallel
+	entry, ok := li.buildEntry(line)
+	if !ok {
+		return nil // Skip duplicate
+	}
+
+	sh := li.shardFor(entry)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	// Update batch time range
+	if entry.Timestamp.Before(sh.batch.StartTime) {
+		sh.batch.StartTime = entry.Timestamp
+	}
+	if entry.Timestamp.After(sh.batch.EndTime) {
+		sh.batch.EndTime = entry.Timestamp
+	}
+
+	sh.batch.Entries = append(sh.batch.Entries, entry)
+	sh.batch.Bytes += estimatedSize(&entry)
+	if ack != nil {
+		sh.batch.trackAck(GetPartitionKey(entry), ack)
+	}
+
+	// Tee accepted lines to the downstream collector
+	if li.forwarder != nil {
+		li.forwarder.Enqueue(entry.Message)
+	}
 
-	li.lineCount++
+	// Flush batch when either the row count or the size target is reached
+	full := *batchSize > 0 && len(sh.batch.Entries) >= *batchSize
+	if *batchBytes > 0 && sh.batch.Bytes >= *batchBytes {
+		full = true
+	}
+	if full && !time.Now().Before(sh.retryAt) {
+		if err := li.flushShard(sh); err != nil {
+			return fmt.Errorf("error flushing batch: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// buildEntry derives a log entry from a line, returning false for duplicates
+func (li *LogIngestor) buildEntry(line string) (LogEntry, bool) {
+	cfg := currentConfig()
+	lineNumber := li.lineCount.Add(1)
+
+	// Strip noisy fields before anything is derived from the line
+	if cfg.dropFields != "" {
+		line = dropJSONFields(line, cfg.dropFields)
+	}
+
+	// Decode JSON once; level, timestamp and the field-based features all read it
+	fields := parseJSONFields(line)
 
 	// Parse timestamp if enabled
 	var timestamp time.Time
 	if *logTimestamps {
-		timestamp = parseTimestamp(line)
+		parsed, ok := parseLineTimestamp(line, fields)
+		if !ok {
+			parsed = li.fallbackTime()
+		}
+		timestamp = parsed
 	} else {
 		timestamp = time.Now()
 	}
 
+	// Extract log level from the message
+	level := extractLevel(line, fields)
+	ingestTime := time.Now()
+
+	var service string
+	if partitionsByService() && fields != nil {
+		service = lookupString(fields, cfg.serviceFields)
+	}
+
 	// Compute content hash for deduplication
 	contentHash := li.computeContentHash(line, timestamp)
 
 	// Check for duplicates if deduplication is enabled
 	if *deduplicate && li.dedupCache != nil {
-		if li.dedupCache.Contains(contentHash) {
-			li.duplicateCount++
-			return nil // Skip duplicate
+		dedupKey := contentHash
+		if *dedupScope == "partition" {
+			// The same message in another partition is not a duplicate
+			dedupKey = GetPartitionKey(LogEntry{Timestamp: timestamp, Level: level, IngestTime: ingestTime, Service: service}) + "|" + contentHash
+		}
+		if !li.dedupCache.AddIfAbsent(dedupKey) {
+			li.duplicateCount.Add(1)
+			return LogEntry{}, false
 		}
-		li.dedupCache.Add(contentHash)
 	}
 
-	// Extract log level from the message
-	level := extractLevel(line)
-
 	// Create log entry
 	entry := LogEntry{
 		Timestamp:   timestamp,
 		Message:     line,
 		Level:       level,
-		LineNumber:  li.lineCount,
+		LineNumber:  lineNumber,
 		ContentHash: contentHash,
+		DocID:       generateDocID(line, timestamp),
+		IngestTime:  ingestTime,
+		Service:     service,
+	}
+
+	// Make files self-describing for readers that ignore Hive paths
+	if *partitionAsColumn {
+		for _, dim := range partitionDims {
+			switch dim {
+			case "date":
+				entry.Date = partitionValue(entry, dim)
+			case "hour":
+				entry.Hour = partitionValue(entry, dim)
+			case "service":
+				entry.ServiceName = partitionValue(entry, dim)
+			}
+		}
+	}
+
+	// Promote configured fields into their own columns
+	entry.Extracted = extractValues(fields)
+
+	// Promote exception details into their own columns
+	if cfg.captureExceptions && fields != nil {
+		entry.ExceptionType = lookupString(fields, cfg.exceptionPaths.Type)
+		entry.ExceptionMessage = lookupString(fields, cfg.exceptionPaths.Message)
+		entry.StackTrace = lookupString(fields, cfg.exceptionPaths.StackTrace)
 	}
 
 	// Track partition for this entry
 	li.partitionTracker.UpdatePartition(entry)
 
-	// Update batch time range
-	if timestamp.Before(li.batch.StartTime) {
-		li.batch.StartTime = timestamp
-	}
-	if timestamp.After(li.batch.EndTime) {
-		li.batch.EndTime = timestamp
+	// Track distinct services and hosts
+	if li.dimensions != nil && fields != nil {
+		li.dimensions.Observe("service", lookupString(fields, cfg.serviceFields))
+		li.dimensions.Observe("host", lookupString(fields, cfg.hostFields))
 	}
 
-	li.batch.Entries = append(li.batch.Entries, entry)
-
-	// Flush batch if full
-	if len(li.batch.Entries) >= *batchSize {
-		if err := li.flushBatch(); err != nil {
-			return fmt.Errorf("error flushing batch: %w", err)
-		}
+	// Estimate distinct values of the configured fields
+	if li.cardinality != nil && fields != nil {
+		li.cardinality.Observe(fields)
 	}
 
-	return nil
+	return entry, true
 }
 
-func (li *LogIngestor) flushBatch() error {
-	if len(li.batch.Entries) == 0 {
+// shardFor returns the shard buffering entry's partition, so each partition is
+// always batched by the same shard
+func (li *LogIngestor) shardFor(entry LogEntry) *batchShard {
+	h := fnv.New32a()
+	h.Write([]byte(GetPartitionKey(entry)))
+	return li.shards[h.Sum32()%uint32(len(li.shards))]
+}
+
+// flushShard writes a shard's batch to storage; sh.mu must be held. On error
+// the batch stays buffered for the next attempt.
+func (li *LogIngestor) flushShard(sh *batchShard) error {
+	if len(sh.batch.Entries) == 0 {
 		return nil
 	}
 
-	if err := flushBatch(li.batch, li.s3Client); err != nil {
+	sh.batch.BatchNumber = int(li.batchNumber.Add(1) - 1)
+	start := time.Now()
+	files, err := flushBatch(sh.batch, li.storage)
+	observeFlush(start)
+
+	li.mu.Lock()
+	defer li.mu.Unlock()
+
+	if err != nil {
+		li.flushErrors++
+		backoff := minFlushBackoff << min(sh.failures, 6)
+		sh.failures++
+		sh.retryAt = time.Now().Add(min(backoff, maxFlushBackoff))
 		return err
 	}
+	sh.failures = 0
+	sh.retryAt = time.Time{}
 
-	li.batchNumber++
-	li.batch = &BatchInfo{
-		Entries:     make([]LogEntry, 0, *batchSize),
-		StartTime:   time.Now(),
-		EndTime:     time.Now(),
-		BatchNumber: li.batchNumber,
+	for _, file := range files {
+		if file.Spilled {
+			li.filesSpilled++
+		} else {
+			li.filesWritten++
+			li.bytesWritten += int64(file.Bytes)
+		}
+		for _, group := range file.groups {
+			for ack := range sh.batch.acks[group] {
+				ack.add(file)
+			}
+		}
 	}
+	li.batchesFlushed++
+	if *maxBatches > 0 && li.batchesFlushed >= *maxBatches && !li.exhausted.Swap(true) {
+		log.Printf("Reached -max-batches limit of %d flushed batches", *maxBatches)
+	}
+	sh.batch = newBatchInfo()
 
 	return nil
 }
 
+// Flush writes every shard's buffered entries to storage
 func (li *LogIngestor) Flush() error {
-	li.mu.Lock()
-	defer li.mu.Unlock()
-	return li.flushBatch()
+	var errs []error
+	for _, sh := range li.shards {
+		sh.mu.Lock()
+		if err := li.flushShard(sh); err != nil {
+			errs = append(errs, err)
+		}
+		sh.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// bufferedEntries returns the number of entries waiting to be flushed
+func (li *LogIngestor) bufferedEntries() int {
+	total := 0
+	for _, sh := range li.shards {
+		sh.mu.Lock()
+		total += len(sh.batch.Entries)
+		sh.mu.Unlock()
+	}
+	return total
+}
+
+// FlushFor flushes all buffered entries and returns the keys of the objects
+// holding the entries recorded in ack. Keys of files that only reached
+// -spill-dir are returned separately.
+func (li *LogIngestor) FlushFor(ack *durableAck) (keys, spilled []string, err error) {
+	if err := li.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	ack.mu.Lock()
+	defer ack.mu.Unlock()
+	return ack.keys, ack.spilled, nil
 }
 
 func (li *LogIngestor) autoFlushWorker() {
@@ -312,9 +773,7 @@ func (li *LogIngestor) autoFlushWorker() {
 	for {
 		select {
 		case <-ticker.C:
-			li.mu.Lock()
-			entryCount := len(li.batch.Entries)
-			li.mu.Unlock()
+			entryCount := li.bufferedEntries()
 
 			if entryCount == 0 {
 				log.Printf("Auto-flush: no data to flush")
@@ -338,123 +797,289 @@ func (li *LogIngestor) Stop() {
 		close(li.stopAutoFlush)
 		<-li.autoFlushStopped
 	}
-	li.Flush()
+	if err := li.Flush(); err != nil {
+		log.Printf("Error flushing on shutdown: %v", err)
+	}
+	if li.forwarder != nil {
+		li.forwarder.Close()
+	}
+	if err := li.writeRunReport(); err != nil {
+		log.Printf("Error writing run report: %v", err)
+	}
 }
 
 func (li *LogIngestor) GetStats() (lineCount int64, partitionCount int, duplicateCount int64, uniqueCount int64) {
-	li.mu.Lock()
-	defer li.mu.Unlock()
-	uniqueCount = li.lineCount - li.duplicateCount
-	return li.lineCount, li.partitionTracker.GetPartitionCount(), li.duplicateCount, uniqueCount
+	// Load duplicates first so a concurrent line can't make uniqueCount negative
+	duplicateCount = li.duplicateCount.Load()
+	lineCount = li.lineCount.Load()
+	uniqueCount = lineCount - duplicateCount
+	return lineCount, li.partitionTracker.GetPartitionCount(), duplicateCount, uniqueCount
 }
 
 func main() {
 	flag.Parse()
 
+	if *configFile != "" {
+		if err := applyConfigFile(*configFile); err != nil {
+			log.Fatalf("Error loading config: %v", err)
+		}
+	}
+
 	if *bucket == "" {
 		fmt.Println("Error: bucket name is required")
 		os.Exit(1)
 	}
 
-	// Create S3 client
-	var s3Client *s3.Client
-	if !*localFile {
-		var cfg aws.Config
-		var err error
+	switch *recordSeparator {
+	case "newline", "rs", "null":
+	default:
+		log.Fatalf("Invalid -record-separator %q (expected newline, rs, or null)", *recordSeparator)
+	}
 
-		if *endpoint != "" {
-			cfg, err = config.LoadDefaultConfig(context.TODO(),
-				config.WithRegion(*region),
-			)
-			if err != nil {
-				log.Fatalf("Failed to load AWS config: %v", err)
-			}
-		} else {
-			cfg, err = config.LoadDefaultConfig(context.TODO())
-			if err != nil {
-				log.Fatalf("Failed to load AWS config: %v", err)
-			}
+	switch *partitionTimeSrc {
+	case "event", "ingest":
+	default:
+		log.Fatalf("Invalid -partition-time-source %q (expected event or ingest)", *partitionTimeSrc)
+	}
+
+	for name, layout := range map[string]string{
+		"filename-date-layout": *fileDateLayout,
+		"filename-hour-layout": *fileHourLayout,
+	} {
+		if err := validateFilenameLayout(layout); err != nil {
+			log.Fatalf("Invalid -%s: %v", name, err)
 		}
+	}
 
-		s3Client = s3.NewFromConfig(cfg, func(o *s3.Options) {
-			if *endpoint != "" {
-				o.BaseEndpoint = aws.String(*endpoint)
-				o.UsePathStyle = true
+	switch *docIDMode {
+	case "none", "uuidv7", "hash":
+	default:
+		log.Fatalf("Invalid -doc-id-mode %q (expected none, uuidv7, or hash)", *docIDMode)
+	}
 
-				if *accessKey != "" && *secretKey != "" {
-					o.Credentials = aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
-						return aws.Credentials{
-							AccessKeyID:     *accessKey,
-							SecretAccessKey: *secretKey,
-						}, nil
-					})
-				}
-			}
-		})
+	if *forwardURL != "" && (*forwardBatch <= 0 || *forwardInterval <= 0) {
+		log.Fatalf("-forward-batch-size and -forward-interval must be positive")
+	}
+
+	options, err := parseCompression(*compression, *compressionLevel)
+	if err != nil {
+		log.Fatalf("Invalid -compression: %v", err)
+	}
+	compressionOptions = options
+
+	if *batchSize < 0 || (*batchSize == 0 && *batchBytes <= 0) {
+		log.Fatalf("Invalid -batch-size %d (must be positive, or 0 with -batch-bytes)", *batchSize)
+	}
+
+	extracted, err := parseExtractFields(*extractFieldSpec)
+	if err != nil {
+		log.Fatalf("Invalid -extract-fields: %v", err)
+	}
+	if len(extracted) > 0 {
+		extractFields = extracted
+		extractSchema = newExtractSchema(extracted)
+	}
+
+	dims, err := parsePartitionBy(*partitionBy)
+	if err != nil {
+		log.Fatalf("Invalid -partition-by: %v", err)
+	}
+	partitionDims = dims
+
+	if *shardCount < 1 {
+		log.Fatalf("Invalid -shards %d (must be at least 1)", *shardCount)
+	}
+	if *gelfWorkers < 1 {
+		log.Fatalf("Invalid -gelf-workers %d (must be at least 1)", *gelfWorkers)
+	}
+
+	switch *dedupKey {
+	case "message", "message+timestamp":
+	default:
+		log.Fatalf("Invalid -dedup-key %q (expected message or message+timestamp)", *dedupKey)
+	}
+
+	if *dedupHashBits < 32 || *dedupHashBits > 256 || *dedupHashBits%4 != 0 {
+		log.Fatalf("Invalid -dedup-hash-bits %d (expected a multiple of 4 from 32 to 256)", *dedupHashBits)
 	}
 
-	// Create output directory if local
-	if *localFile {
-		if err := os.MkdirAll(*bucket, 0755); err != nil {
-			log.Fatalf("Failed to create output directory: %v", err)
+	switch *dedupScope {
+	case "global", "partition":
+	default:
+		log.Fatalf("Invalid -dedup-scope %q (expected global or partition)", *dedupScope)
+	}
+
+	if err := loadLiveConfig(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if serverTLS, err = loadServerTLS(); err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+
+	storage, err := newStorage()
+	if err != nil {
+		log.Fatalf("Failed to set up storage: %v", err)
+	}
+
+	if *selfTest {
+		if err := runSelfTest(storage); err != nil {
+			log.Fatalf("Storage self-test failed: %v", err)
+		}
+	}
+
+	if *emitDDL {
+		if err := writeDDL(storage); err != nil {
+			log.Fatalf("Failed to write table DDL: %v", err)
 		}
 	}
 
+	if spill, ok := storage.(*spillStorage); ok && *drainSpill {
+		spill.drainSpill(context.TODO())
+	}
+
 	if *httpMode {
-		runHTTPServer(s3Client)
+		runHTTPServer(storage)
 	} else {
-		runStdinMode(s3Client)
+		runStdinMode(storage)
 	}
 }
 
-func runHTTPServer(s3Client *s3.Client) {
-	ingestor := NewLogIngestor(s3Client)
+func runHTTPServer(storage Storage) {
+	ingestor := NewLogIngestor(storage)
+
+	// Cancelled on SIGINT/SIGTERM to stop listeners and flush
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Listener goroutines and connections are tracked so shutdown can stop
+	// them all before the final flush
+	conns := newConnGroup()
 
 	// Start GELF TCP server in a goroutine (more reliable than UDP)
-	go func() {
-		if err := StartGELFTCPServer(":12201", ingestor); err != nil {
-			log.Fatalf("Failed to start GELF TCP server: %v", err)
-		}
-	}()
+	gelfQueue := NewGELFQueue(*gelfQueueSize, *gelfWorkers, ingestor)
+	if *gelfTCP {
+		conns.Go(func() {
+			if err := StartGELFTCPServer(ctx, *gelfTCPAddr, gelfTLSConfig(), gelfQueue, conns); err != nil {
+				log.Fatalf("Failed to start GELF TCP server: %v", err)
+			}
+		})
+	}
+	if *gelfUDP {
+		conns.Go(func() {
+			// UDP is optional, so keep serving HTTP and TCP if it can't bind
+			if err := StartGELFUDPServer(ctx, *gelfUDPAddr, ingestor, conns); err != nil {
+				log.Printf("GELF UDP server disabled: %v", err)
+			}
+		})
+	}
+	if *syslogEnabled {
+		conns.Go(func() {
+			if err := StartSyslogTCPServer(ctx, *syslogAddr, ingestor, conns); err != nil {
+				log.Fatalf("Failed to start syslog TCP server: %v", err)
+			}
+		})
+		conns.Go(func() {
+			if err := StartSyslogUDPServer(ctx, *syslogAddr, ingestor); err != nil {
+				log.Printf("Syslog UDP server disabled: %v", err)
+			}
+		})
+	}
 
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
-	http.HandleFunc("/ingest", func(w http.ResponseWriter, r *http.Request) {
+	// Readiness reports unhealthy while the storage circuit breaker is open
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if breakerStateOf(ingestor.storage) == breakerOpen {
+			http.Error(w, "storage circuit breaker open", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	http.HandleFunc("/ingest", requireClientCert(requireToken(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		body, err := io.ReadAll(r.Body)
+		defer r.Body.Close()
+
+		// Decompress while streaming so large uploads are never buffered whole
+		reader, err := decompressBody(r)
 		if err != nil {
-			http.Error(w, "Error reading body", http.StatusBadRequest)
+			writeDecompressError(w, err)
 			return
 		}
-		defer r.Body.Close()
 
-		// Process each line
-		scanner := bufio.NewScanner(bytes.NewReader(body))
+		// Durable mode waits for the submitted entries to reach storage
+		durable := r.URL.Query().Get("durable") == "true" || strings.Contains(r.Header.Get("Prefer"), "wait=flush")
+		var ack *durableAck
+		if durable {
+			ack = newDurableAck()
+		}
+
 		linesProcessed := 0
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line == "" {
-				continue
+		if *bodyAsSingle || r.Header.Get("X-Body-As-Single-Entry") == "true" {
+			// Webhook-style producers send one multi-line document per request
+			body, err := io.ReadAll(io.LimitReader(reader, maxLineBytes))
+			if err != nil {
+				http.Error(w, "Error reading body", http.StatusBadRequest)
+				return
 			}
-			if err := ingestor.ProcessLine(line); err != nil {
-				log.Printf("Error processing line: %v", err)
-				http.Error(w, "Error processing logs", http.StatusInternalServerError)
+			entry := strings.TrimSpace(string(body))
+			if entry != "" {
+				if err := ingestor.ProcessDurableLine(entry, ack); err != nil {
+					if errors.Is(err, ErrMaxBatchesReached) {
+						http.Error(w, err.Error(), http.StatusServiceUnavailable)
+						return
+					}
+					log.Printf("Error processing body: %v", err)
+					http.Error(w, "Error processing logs", http.StatusInternalServerError)
+					return
+				}
+				linesProcessed++
+			}
+		} else {
+			// Process each line as it arrives
+			scanner := newLineScanner(reader)
+			scanner.Split(recordSplitFunc())
+			for scanner.Scan() {
+				line := scanner.Text()
+				if line == "" {
+					continue
+				}
+				if err := ingestor.ProcessDurableLine(line, ack); err != nil {
+					if errors.Is(err, ErrMaxBatchesReached) {
+						http.Error(w, err.Error(), http.StatusServiceUnavailable)
+						return
+					}
+					log.Printf("Error processing line: %v", err)
+					http.Error(w, "Error processing logs", http.StatusInternalServerError)
+					return
+				}
+				linesProcessed++
+			}
+
+			if err := scanner.Err(); err != nil {
+				log.Printf("Error scanning input: %v", err)
+				http.Error(w, "Error scanning input", http.StatusInternalServerError)
 				return
 			}
-			linesProcessed++
 		}
 
-		if err := scanner.Err(); err != nil {
-			log.Printf("Error scanning input: %v", err)
-			http.Error(w, "Error scanning input", http.StatusInternalServerError)
-			return
+		var keys, spilled []string
+		if durable {
+			keys, spilled, err = ingestor.FlushFor(ack)
+			if err != nil {
+				log.Printf("Error flushing durable ingest: %v", err)
+				http.Error(w, "Error flushing logs", http.StatusInternalServerError)
+				return
+			}
 		}
 
 		lineCount, partitionCount, duplicateCount, uniqueCount := ingestor.GetStats()
@@ -465,15 +1090,24 @@ func runHTTPServer(s3Client *s3.Client) {
 			"partitions":      partitionCount,
 			"unique_lines":    uniqueCount,
 		}
+		if durable {
+			response["durable"] = true
+			response["keys"] = keys
+			if len(spilled) > 0 {
+				// Saved locally but not yet in storage
+				response["status"] = "spilled"
+				response["spilled_keys"] = spilled
+			}
+		}
 		if *deduplicate {
 			response["duplicates_skipped"] = duplicateCount
 			response["dedup_cache_size"] = ingestor.dedupCache.Size()
 		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(response)
-	})
+	})))
 
-	http.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/flush", requireToken(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -498,14 +1132,30 @@ func runHTTPServer(s3Client *s3.Client) {
 		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(response)
-	})
+	}))
 
-	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/stats", requireToken(func(w http.ResponseWriter, r *http.Request) {
 		lineCount, partitionCount, duplicateCount, uniqueCount := ingestor.GetStats()
 		response := map[string]interface{}{
-			"total_lines":  lineCount,
-			"unique_lines": uniqueCount,
-			"partitions":   partitionCount,
+			"total_lines":     lineCount,
+			"unique_lines":    uniqueCount,
+			"partitions":      partitionCount,
+			"backends":        backendMetricsSnapshot(),
+			"storage_breaker": breakerStateOf(ingestor.storage),
+			"gelf_queue": map[string]interface{}{
+				"depth":    gelfQueue.Depth(),
+				"capacity": gelfQueue.Capacity(),
+				"stalls":   gelfQueue.Stalls(),
+			},
+		}
+		if multi, ok := multiStorageOf(ingestor.storage); ok {
+			response["partial_writes"] = multi.PartialWrites()
+		}
+		if ingestor.cardinality != nil {
+			response["cardinality"] = ingestor.cardinality.Estimates()
+		}
+		if ingestor.forwarder != nil {
+			response["forward"] = ingestor.forwarder.Stats()
 		}
 		if *deduplicate {
 			response["duplicates_skipped"] = duplicateCount
@@ -516,48 +1166,42 @@ func runHTTPServer(s3Client *s3.Client) {
 		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(response)
-	})
+	}))
+
+	// Prometheus text-format metrics
+	http.HandleFunc("/metrics", requireToken(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writePrometheusMetrics(w, ingestor, gelfQueue)
+	}))
+
+	http.HandleFunc("/dimensions", requireToken(func(w http.ResponseWriter, r *http.Request) {
+		if ingestor.dimensions == nil {
+			http.Error(w, "Dimension tracking disabled (enable with -track-dimensions)", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ingestor.dimensions.Snapshot())
+	}))
 
 	addr := ":" + *httpPort
 	// GELF endpoint for Docker GELF logging driver
-	http.HandleFunc("/gelf", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/gelf", requireClientCert(requireToken(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
 		// Read and potentially decompress body
-		var reader io.Reader = r.Body
-		contentEncoding := r.Header.Get("Content-Encoding")
-
-		switch contentEncoding {
-		case "gzip":
-			gzReader, err := gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "Error decompressing gzip", http.StatusBadRequest)
-				return
-			}
-			defer gzReader.Close()
-			reader = gzReader
-		case "deflate":
-			zlibReader, err := zlib.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "Error decompressing deflate", http.StatusBadRequest)
-				return
-			}
-			defer zlibReader.Close()
-			reader = zlibReader
-		}
-
-		body, err := io.ReadAll(reader)
+		reader, err := decompressBody(r)
 		if err != nil {
-			http.Error(w, "Error reading body", http.StatusBadRequest)
+			writeDecompressError(w, err)
 			return
 		}
+
 		defer r.Body.Close()
 
 		// GELF can be sent as individual JSON objects or newline-delimited
-		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner := newLineScanner(reader)
 		linesProcessed := 0
 
 		for scanner.Scan() {
@@ -599,24 +1243,210 @@ func runHTTPServer(s3Client *s3.Client) {
 		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(response)
-	})
+	})))
+
+	// OTLP/HTTP logs endpoint for OpenTelemetry collectors and SDKs
+	http.HandleFunc("/v1/logs", requireClientCert(requireToken(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		defer r.Body.Close()
+
+		var protobuf bool
+		switch mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType {
+		case "application/x-protobuf":
+			protobuf = true
+		case "application/json":
+		default:
+			http.Error(w, "Content-Type must be application/x-protobuf or application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		reader, err := decompressBody(r)
+		if err != nil {
+			writeDecompressError(w, err)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(reader, maxLineBytes+1))
+		if err != nil {
+			http.Error(w, "Error reading body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxLineBytes {
+			http.Error(w, fmt.Sprintf("Request exceeds %d bytes", maxLineBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		var request otlpRequest
+		if protobuf {
+			request, err = decodeOTLPProto(body)
+		} else {
+			request, err = decodeOTLPJSON(body)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid OTLP request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		// Rejected records are reported as a partial success; only a batch
+		// refused outright because of -max-batches is worth retrying
+		accepted, rejected, err := ingestor.ProcessOTLP(request)
+		if rejected > 0 && accepted == 0 && errors.Is(err, ErrMaxBatchesReached) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		message := ""
+		if err != nil {
+			log.Printf("OTLP request: %d records rejected, last error: %v", rejected, err)
+			message = err.Error()
+		}
+
+		if protobuf {
+			w.Header().Set("Content-Type", "application/x-protobuf")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(otlpResponse(protobuf, rejected, message))
+	})))
 
 	log.Printf("Starting HTTP ingestor on %s", addr)
-	log.Printf("GELF TCP server on :12201")
-	log.Printf("POST logs to http://localhost%s/ingest", addr)
-	log.Printf("POST GELF logs to http://localhost%s/gelf", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	if *gelfTCP && *gelfTLS {
+		log.Printf("GELF TCP server on %s (TLS)", *gelfTCPAddr)
+	} else if *gelfTCP {
+		log.Printf("GELF TCP server on %s", *gelfTCPAddr)
+	}
+	if *gelfUDP {
+		log.Printf("GELF UDP server on %s", *gelfUDPAddr)
+	}
+	if *syslogEnabled {
+		log.Printf("Syslog TCP/UDP server on %s", *syslogAddr)
+	}
+	scheme := "http"
+	if serverTLS != nil {
+		scheme = "https"
+	}
+	log.Printf("POST logs to %s://localhost%s/ingest", scheme, addr)
+	log.Printf("POST GELF logs to %s://localhost%s/gelf", scheme, addr)
+	log.Printf("POST OTLP logs to %s://localhost%s/v1/logs", scheme, addr)
+
+	// Flush and write the run report when asked to stop
+	server := &http.Server{Addr: addr, TLSConfig: serverTLS}
+	var deadline time.Time
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Printf("Shutting down HTTP ingestor (timeout %v)...", *shutdownTimeout)
+		deadline = time.Now().Add(*shutdownTimeout)
+		shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	var err error
+	if serverTLS != nil {
+		// The certificate is already loaded into TLSConfig
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	// Let in-flight requests finish before the final flush
+	<-shutdownDone
+
+	// A stuck upload must not hang shutdown past the deadline. Every producer
+	// is stopped first so no line arrives after the final flush.
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		conns.Close()
+		gelfQueue.Close()
+		ingestor.Stop()
+	}()
+	select {
+	case <-stopped:
+		log.Printf("Shutdown complete")
+	case <-time.After(time.Until(deadline)):
+		log.Fatalf("Shutdown timed out after %v; buffered entries may be lost", *shutdownTimeout)
+	}
 }
 
-func runStdinMode(s3Client *s3.Client) {
-	ingestor := NewLogIngestor(s3Client)
+// backfillFallbackTime returns -default-time if set, otherwise the file's modification time
+func backfillFallbackTime(f *os.File) (time.Time, error) {
+	if *defaultTime != "" {
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if t, err := time.Parse(layout, *defaultTime); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid -default-time %q (expected RFC3339 or 2006-01-02)", *defaultTime)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// breakerStateOf returns the circuit breaker state of a storage backend, or "disabled"
+func breakerStateOf(storage Storage) string {
+	if guarded, ok := unwrapSpill(storage).(*breakerStorage); ok {
+		return guarded.BreakerState()
+	}
+	return "disabled"
+}
+
+func runStdinMode(storage Storage) {
+	ingestor := NewLogIngestor(storage)
 	defer ingestor.Stop()
 
-	// Read from stdin
-	scanner := bufio.NewScanner(os.Stdin)
+	// Read from stdin, or from a file when backfilling with -input
+	var input io.Reader = os.Stdin
+	if *inputFile != "" {
+		f, err := os.Open(*inputFile)
+		if err != nil {
+			log.Fatalf("Error opening input file: %v", err)
+		}
+		defer f.Close()
+		input = f
+
+		// Lines without a timestamp belong to the file's era, not today
+		fallback, err := backfillFallbackTime(f)
+		if err != nil {
+			log.Fatalf("Error determining fallback time: %v", err)
+		}
+		ingestor.fallbackTime = func() time.Time { return fallback }
+	}
+
+	// Backfill can outpace live traffic by orders of magnitude, so pace it
+	// to stay within backend request quotas
+	var throttle *ReplayThrottle
+	if *inputFile != "" {
+		var err error
+		throttle, err = parseReplayRate(*replayRate)
+		if err != nil {
+			log.Fatalf("Invalid -replay-rate: %v", err)
+		}
+		if throttle != nil {
+			log.Printf("Replay throttled to %s", throttle)
+		}
+	}
+
+	scanner := newLineScanner(input)
+	scanner.Split(recordSplitFunc())
 
 	fmt.Println("Starting log ingestion...")
-	fmt.Println("Reading from stdin, press Ctrl+D to finish...")
+	if *inputFile != "" {
+		fmt.Printf("Reading from %s...\n", *inputFile)
+	} else {
+		fmt.Println("Reading from stdin, press Ctrl+D to finish...")
+	}
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -624,7 +1454,15 @@ func runStdinMode(s3Client *s3.Client) {
 			continue
 		}
 
+		if throttle != nil {
+			throttle.Wait(line)
+		}
+
 		if err := ingestor.ProcessLine(line); err != nil {
+			if errors.Is(err, ErrMaxBatchesReached) {
+				log.Printf("Stopping: %v", err)
+				break
+			}
 			log.Printf("Error processing line: %v", err)
 		}
 
@@ -635,7 +1473,10 @@ func runStdinMode(s3Client *s3.Client) {
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("Error reading input: %v", err)
+		// Flush what was read before exiting; os.Exit skips the deferred Stop
+		log.Printf("Error reading input: %v", err)
+		ingestor.Stop()
+		os.Exit(1)
 	}
 
 	lineCount, partitionCount, duplicateCount, uniqueCount := ingestor.GetStats()
@@ -648,7 +1489,82 @@ func runStdinMode(s3Client *s3.Client) {
 	fmt.Printf("Total partitions created: %d\n", partitionCount)
 }
 
-func flushBatch(batch *BatchInfo, s3Client *s3.Client) error {
+// ErrUnsupportedEncoding is returned by decompressBody for Content-Encoding
+// values other than gzip, deflate and identity
+var ErrUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// decompressBody wraps the request body according to its Content-Encoding
+func decompressBody(r *http.Request) (io.Reader, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip", "x-gzip":
+		gzReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing gzip: %w", err)
+		}
+		return gzReader, nil
+	case "deflate":
+		zlibReader, err := zlib.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing deflate: %w", err)
+		}
+		return zlibReader, nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, encoding)
+	}
+}
+
+// writeDecompressError maps decompressBody errors to 415 or 400 responses
+func writeDecompressError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUnsupportedEncoding) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// maxLineBytes caps a single scanned record (or a single-entry body)
+const maxLineBytes = 16 << 20
+
+// newLineScanner scans r record by record, allowing lines up to maxLineBytes
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	return scanner
+}
+
+// recordSplitFunc returns the scanner split function for -record-separator
+func recordSplitFunc() bufio.SplitFunc {
+	switch *recordSeparator {
+	case "rs":
+		return splitOnByte(0x1e)
+	case "null":
+		return splitOnByte(0)
+	default:
+		return bufio.ScanLines
+	}
+}
+
+// splitOnByte splits input into records terminated by sep, trimming the
+// surrounding whitespace (json-seq records are RS-prefixed and LF-terminated)
+func splitOnByte(sep byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, bytes.TrimSpace(data[:i]), nil
+		}
+		if atEOF {
+			return len(data), bytes.TrimSpace(data), nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// flushBatch writes a batch to storage and returns the files written
+func flushBatch(batch *BatchInfo, storage Storage) ([]ManifestFile, error) {
 	// Group entries by partition key
 	partitionGroups := make(map[string][]LogEntry)
 	for _, entry := range batch.Entries {
@@ -659,11 +1575,13 @@ func flushBatch(batch *BatchInfo, s3Client *s3.Client) error {
 		partitionGroups[partitionKey] = append(partitionGroups[partitionKey], entry)
 	}
 
+	// Generate filename (no part suffix needed - directory structure indicates partition)
+	baseFileName := generateFileName(batch.StartTime, batch.EndTime, batch.BatchNumber)
+
 	// Process each partition group
+	var files []ManifestFile
+	smallGroups := make(map[string]encodedFile)
 	for partitionKey, entries := range partitionGroups {
-		// Generate filename (no part suffix needed - directory structure indicates partition)
-		baseFileName := generateFileName(batch.StartTime, batch.EndTime, batch.BatchNumber)
-
 		var fileName string
 		if partitionKey != "unpartitioned" {
 			fileName = fmt.Sprintf("%s/%s", partitionKey, baseFileName)
@@ -671,186 +1589,651 @@ func flushBatch(batch *BatchInfo, s3Client *s3.Client) error {
 			fileName = baseFileName
 		}
 
-		// Create parquet writer
-		var buf bytes.Buffer
-		writer := parquet.NewGenericWriter[LogEntry](&buf, getCompression()...)
-
-		// Write entries for this partition
-		_, err := writer.Write(entries)
+		// Encode entries, splitting into parts if the file would exceed the target size
+		parts, err := encodePartitionFiles(entries)
 		if err != nil {
-			return fmt.Errorf("error writing to parquet: %w", err)
+			return files, err
 		}
 
-		if err := writer.Close(); err != nil {
-			return fmt.Errorf("error closing parquet writer: %w", err)
+		// Hold back tiny partitions so they can be packed into one shared file
+		if *combineSmall && partitionKey != "unpartitioned" && len(parts) == 1 && int64(len(parts[0].data)) < *minFileBytes {
+			smallGroups[partitionKey] = parts[0]
+			continue
 		}
 
-		data := buf.Bytes()
-
-		// Upload to S3 or write locally
-		if *localFile {
-			// Write to local file
-			localPath := fmt.Sprintf("%s/%s/%s", *bucket, *prefix, fileName)
-			dir := localPath[:strings.LastIndex(localPath, "/")]
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return fmt.Errorf("error creating directory: %w", err)
+		for i, part := range parts {
+			partFileName := fileName
+			if len(parts) > 1 {
+				partFileName = partFileNameFor(fileName, i)
 			}
-			if err := os.WriteFile(localPath, data, 0644); err != nil {
-				return fmt.Errorf("error writing local file: %w", err)
+			file, err := writeObject(storage, partFileName, manifestPartition(partitionKey), part)
+			if err != nil {
+				return files, err
 			}
-			log.Printf("Wrote %d entries to %s (%d bytes)\n", len(entries), localPath, len(data))
-		} else {
-			// Upload to S3
-			key := fmt.Sprintf("%s/%s", *prefix, fileName)
-			_, err := s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-				Bucket: aws.String(*bucket),
-				Key:    aws.String(key),
-				Body:   bytes.NewReader(data),
-			})
+			file.groups = []string{partitionKey}
+			files = append(files, file)
+		}
+	}
+
+	combinedFiles, err := writeCombinedPartitions(storage, baseFileName, smallGroups)
+	files = append(files, combinedFiles...)
+	if err != nil {
+		return files, err
+	}
+
+	// Index the batch only once all of its data is written
+	writeManifest(storage, baseFileName, batch.BatchNumber, files)
+	if *successMarkers {
+		writeSuccessMarkers(storage, files)
+	}
+	return files, nil
+}
+
+// manifestPartition returns the partition recorded in manifests for a group key
+func manifestPartition(partitionKey string) string {
+	if partitionKey == "unpartitioned" {
+		return ""
+	}
+	return partitionKey
+}
+
+// writeCombinedPartitions packs small partition groups into a single file at the
+// prefix root, recording each entry's partition in the partition column. A lone
+// small partition is written to its own directory as usual.
+func writeCombinedPartitions(storage Storage, baseFileName string, smallGroups map[string]encodedFile) ([]ManifestFile, error) {
+	if len(smallGroups) == 0 {
+		return nil, nil
+	}
+
+	if len(smallGroups) == 1 {
+		for partitionKey, group := range smallGroups {
+			file, err := writeObject(storage, fmt.Sprintf("%s/%s", partitionKey, baseFileName), partitionKey, group)
 			if err != nil {
-				return fmt.Errorf("error uploading to S3: %w", err)
+				return nil, err
+			}
+			file.groups = []string{partitionKey}
+			return []ManifestFile{file}, nil
+		}
+	}
+
+	partitionKeys := make([]string, 0, len(smallGroups))
+	for partitionKey := range smallGroups {
+		partitionKeys = append(partitionKeys, partitionKey)
+	}
+	sort.Strings(partitionKeys)
+
+	var combined []LogEntry
+	for _, partitionKey := range partitionKeys {
+		for _, entry := range smallGroups[partitionKey].entries {
+			entry.Partition = partitionKey
+			combined = append(combined, entry)
+		}
+	}
+
+	data, err := encodeParquet(combined)
+	if err != nil {
+		return nil, err
+	}
+
+	fileName := strings.TrimSuffix(baseFileName, ".parquet") + "_combined.parquet"
+	file, err := writeObject(storage, fileName, "", encodedFile{entries: combined, data: data})
+	if err != nil {
+		return nil, err
+	}
+	file.groups = partitionKeys
+	return []ManifestFile{file}, nil
+}
+
+// encodedFile is a parquet-encoded slice of a partition's entries
+type encodedFile struct {
+	entries []LogEntry
+	data    []byte
+}
+
+// encodePartitionFiles encodes a partition's entries, starting a new file
+// whenever the encoded size reaches -target-file-bytes. Entries are written a
+// row group at a time and the size checked after each, so every entry is
+// encoded once.
+func encodePartitionFiles(entries []LogEntry) ([]encodedFile, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	if *targetFileBytes <= 0 {
+		data, err := encodeParquet(entries)
+		if err != nil {
+			return nil, err
+		}
+		return []encodedFile{{entries: entries, data: data}}, nil
+	}
+
+	var files []encodedFile
+	var buf bytes.Buffer
+	writer := newEntryWriter(&buf)
+	start := 0
+	for i := 0; i < len(entries); {
+		end := i + rowGroupRows(entries[start:i], entries[i:], int64(buf.Len()))
+		if err := writer.writeEntries(entries[i:end]); err != nil {
+			return nil, err
+		}
+		if err := writer.Flush(); err != nil {
+			return nil, fmt.Errorf("error flushing parquet row group: %w", err)
+		}
+		i = end
+
+		if int64(buf.Len()) >= *targetFileBytes || i == len(entries) {
+			if err := writer.Close(); err != nil {
+				return nil, fmt.Errorf("error closing parquet writer: %w", err)
 			}
-			log.Printf("Uploaded %d entries to s3://%s/%s (%d bytes)\n", len(entries), *bucket, key, len(data))
+			files = append(files, encodedFile{entries: entries[start:i], data: bytes.Clone(buf.Bytes())})
+			buf.Reset()
+			writer = newEntryWriter(&buf)
+			start = i
 		}
 	}
+	return files, nil
+}
 
+// rowGroupRows picks how many of the pending entries to write as the next row
+// group so the file lands near -target-file-bytes. The first row group is
+// sized from the uncompressed estimate; later ones from the bytes per row
+// encoded so far.
+func rowGroupRows(written, pending []LogEntry, size int64) int {
+	remaining := *targetFileBytes - size
+	rows := 0
+	if len(written) > 0 && size > 0 {
+		rows = int(remaining * int64(len(written)) / size)
+	} else {
+		for rows < len(pending) && remaining > 0 {
+			remaining -= estimatedSize(&pending[rows])
+			rows++
+		}
+	}
+	return max(1, min(rows, len(pending)))
+}
+
+// entryWriter encodes entries into a parquet file
+type entryWriter interface {
+	writeEntries(entries []LogEntry) error
+	Flush() error // ends the current row group
+	Close() error
+}
+
+// newEntryWriter returns a writer for the LogEntry schema, or the extended
+// schema when -extract is set
+func newEntryWriter(w io.Writer) entryWriter {
+	if extractSchema != nil {
+		return extractWriter{parquet.NewWriter(w, append(getWriterOptions(), extractSchema)...)}
+	}
+	return logEntryWriter{parquet.NewGenericWriter[LogEntry](w, getWriterOptions()...)}
+}
+
+type logEntryWriter struct {
+	*parquet.GenericWriter[LogEntry]
+}
+
+func (w logEntryWriter) writeEntries(entries []LogEntry) error {
+	if _, err := w.Write(entries); err != nil {
+		return fmt.Errorf("error writing to parquet: %w", err)
+	}
 	return nil
 }
 
-func extractLevel(message string) string {
-	// Only try JSON extraction if message looks like JSON
-	if !strings.HasPrefix(message, "{") {
+// encodeParquet writes entries to an in-memory parquet file
+func encodeParquet(entries []LogEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := newEntryWriter(&buf)
+	if err := writer.writeEntries(entries); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("error closing parquet writer: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeObject writes an encoded file to storage and returns its manifest
+// entry. Its row count and time range go along as object metadata.
+func writeObject(storage Storage, fileName, partition string, file encodedFile) (ManifestFile, error) {
+	key := fmt.Sprintf("%s/%s", *prefix, fileName)
+	manifestFile := newManifestFile(key, partition, file.entries, len(file.data))
+	ctx := withObjectMetadata(context.TODO(), map[string]string{
+		"rows":          strconv.Itoa(manifestFile.Rows),
+		"min-timestamp": manifestFile.MinTimestamp.UTC().Format(time.RFC3339Nano),
+		"max-timestamp": manifestFile.MaxTimestamp.UTC().Format(time.RFC3339Nano),
+	})
+	if err := storage.Put(ctx, key, file.data); errors.Is(err, ErrSpilled) {
+		// The data is safe locally; record where it is instead of failing the flush
+		manifestFile.Spilled = true
+		return manifestFile, nil
+	} else if err != nil {
+		return ManifestFile{}, err
+	}
+	log.Printf("Wrote %d entries to %s/%s (%d bytes)\n", len(file.entries), storage.Name(), key, len(file.data))
+	return manifestFile, nil
+}
+
+// extractLevel determines the level of a line from its decoded JSON fields,
+// or from its text when it is not valid JSON (fields is nil), falling back to
+// keyword inference
+func extractLevel(message string, fields map[string]interface{}) string {
+	level := levelFromFields(fields)
+	if fields == nil {
+		level = levelFromText(message)
+	}
+
+	// Keyword inference is the lowest priority source of a level
+	if level == "unknown" && currentConfig().inferLevel {
+		if inferred := inferLevelFromKeywords(message); inferred != "" {
+			return inferred
+		}
+	}
+
+	return level
+}
+
+// levelFromFields reads the first -level-fields path present, accepting
+// textual levels and numeric severities
+func levelFromFields(fields map[string]interface{}) string {
+	if fields == nil {
 		return "unknown"
 	}
 
-	// Try each configured level field
-	fields := strings.Split(*levelFields, ",")
-	for _, field := range fields {
-		field = strings.TrimSpace(field)
-		if field == "" {
+	for _, path := range strings.Split(currentConfig().levelFields, ",") {
+		value, ok := lookupField(fields, strings.TrimSpace(path))
+		if !ok {
 			continue
 		}
 
-		// Check if field exists in message
-		if !strings.Contains(message, fmt.Sprintf(`"%s"`, field)) {
-			continue
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				return normalizeLevel(v)
+			}
+		case json.Number:
+			if level := severityLevel(v.String()); level != "" {
+				return level
+			}
 		}
+	}
 
-		// Try to extract string value
-		pattern := regexp.MustCompile(fmt.Sprintf(`"%s"\s*:\s*"([^"]+)"`, regexp.QuoteMeta(field)))
-		matches := pattern.FindStringSubmatch(message)
-		if len(matches) > 1 {
-			level := strings.ToLower(matches[1])
-			// Normalize common variations
-			switch level {
-			case "warning":
-				return "warn"
-			case "err":
-				return "error"
-			case "trace":
-				return "debug"
-			case "fatal", "critical":
-				return "error"
-			default:
+	return "unknown"
+}
+
+// levelFromText reads the first -level-fields key found in a line that is
+// not valid JSON, such as logfmt or truncated JSON
+func levelFromText(line string) string {
+	for _, key := range strings.Split(currentConfig().levelFields, ",") {
+		value, quoted, ok := textField(line, strings.TrimSpace(key))
+		if !ok || value == "" {
+			continue
+		}
+		if !quoted {
+			if level := severityLevel(value); level != "" {
 				return level
 			}
+			if _, err := strconv.ParseFloat(value, 64); err == nil {
+				continue
+			}
+		}
+		return normalizeLevel(value)
+	}
+	return "unknown"
+}
+
+// severityLevel maps a numeric severity to a level, or returns "" when s is
+// not a number in a known range
+func severityLevel(s string) string {
+	// Common numeric mappings (syslog-style: 0-7, OTLP: 1-24)
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case n >= 1 && n <= 4:
+		return "debug"
+	case n >= 5 && n <= 8:
+		return "info"
+	case n >= 9 && n <= 12:
+		return "warn"
+	case n >= 13:
+		return "error"
+	}
+	return ""
+}
+
+// textField returns the last value of key in a line that is not valid JSON,
+// written either as a JSON member ("key": "value" or "key": 42) or as a
+// logfmt pair (key=value or key="value"). quoted reports a string value.
+func textField(line, key string) (value string, quoted, ok bool) {
+	if key == "" || !strings.Contains(line, key) {
+		return "", false, false
+	}
+
+	matches := textFieldPattern(key).FindAllStringSubmatchIndex(line, -1)
+	if len(matches) == 0 {
+		return "", false, false
+	}
+
+	// Groups 1 and 3 are quoted values, 2 and 4 bare ones
+	last := matches[len(matches)-1]
+	for group := 1; group <= 4; group++ {
+		if start, end := last[2*group], last[2*group+1]; start >= 0 {
+			return line[start:end], group%2 == 1, true
 		}
+	}
+	return "", false, false
+}
+
+// normalizeLevel lowercases a level and maps common variations
+func normalizeLevel(level string) string {
+	level = strings.ToLower(level)
+	switch level {
+	case "warning":
+		return "warn"
+	case "err":
+		return "error"
+	case "trace":
+		return "debug"
+	case "fatal", "panic", "critical":
+		return "error"
+	default:
+		return level
+	}
+}
 
-		// Try to extract number value (e.g., severityNumber)
-		numPattern := regexp.MustCompile(fmt.Sprintf(`"%s"\s*:\s*(\d+)`, regexp.QuoteMeta(field)))
-		numMatches := numPattern.FindStringSubmatch(message)
-		if len(numMatches) > 1 {
-			// Common numeric mappings (syslog-style: 0-7, OTLP: 1-24)
-			num := numMatches[1]
-			switch {
-			case num >= "1" && num <= "4":
-				return "debug"
-			case num >= "5" && num <= "8":
-				return "info"
-			case num >= "9" && num <= "12":
-				return "warn"
-			case num >= "13":
-				return "error"
+// lastSubmatch returns the first capture group of the last match of pattern in s
+// and the offset of that match, or -1 when there is no match
+func lastSubmatch(pattern *regexp.Regexp, s string) (string, int) {
+	matches := pattern.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return "", -1
+	}
+	last := matches[len(matches)-1]
+	return s[last[2]:last[3]], last[0]
+}
+
+// levelKeywordRule maps a set of message keywords to a level
+type levelKeywordRule struct {
+	level   string
+	pattern *regexp.Regexp
+}
+
+// parseLevelKeywords parses rules in the form "error:error|fatal,warn:warn|warning"
+func parseLevelKeywords(spec string) ([]levelKeywordRule, error) {
+	var rules []levelKeywordRule
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		level, keywords, ok := strings.Cut(rule, ":")
+		if !ok || strings.TrimSpace(level) == "" || strings.TrimSpace(keywords) == "" {
+			return nil, fmt.Errorf("invalid level keyword rule %q (expected level:keyword|keyword)", rule)
+		}
+
+		var quoted []string
+		for _, kw := range strings.Split(keywords, "|") {
+			kw = strings.TrimSpace(kw)
+			if kw != "" {
+				quoted = append(quoted, regexp.QuoteMeta(kw))
 			}
 		}
+		if len(quoted) == 0 {
+			return nil, fmt.Errorf("level keyword rule %q has no keywords", rule)
+		}
+
+		rules = append(rules, levelKeywordRule{
+			level:   strings.ToLower(strings.TrimSpace(level)),
+			pattern: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`),
+		})
 	}
+	return rules, nil
+}
 
-	return "unknown"
+// inferLevelFromKeywords returns the level of the first rule whose keywords appear in the message
+func inferLevelFromKeywords(message string) string {
+	for _, rule := range currentConfig().levelKeywordRules {
+		if rule.pattern.MatchString(message) {
+			return rule.level
+		}
+	}
+	return ""
 }
 
 func generateFileName(start, end time.Time, batchNum int) string {
-	dateStr := start.Format("2006-01-02")
-	hour := start.Format("15")
+	start = start.Local() // match the local-time partition path
+	dateStr := start.Format(*fileDateLayout)
+	hour := start.Format(*fileHourLayout)
 	startSec := start.Unix()
 	return fmt.Sprintf("logs_%s_%s_%d_batch%04d.parquet", dateStr, hour, startSec, batchNum)
 }
 
-func getCompression() []parquet.WriterOption {
-	switch strings.ToLower(*compression) {
+// validateFilenameLayout checks that a layout contains time elements and
+// formats to a single path segment
+func validateFilenameLayout(layout string) error {
+	sample := time.Date(2011, 11, 22, 13, 14, 15, 0, time.UTC).Format(layout)
+	if layout == "" || sample == layout {
+		return fmt.Errorf("layout %q contains no time elements", layout)
+	}
+	if strings.ContainsAny(sample, "/\\") {
+		return fmt.Errorf("layout %q produces a path separator (%q)", layout, sample)
+	}
+	return nil
+}
+
+// partFileNameFor inserts a _partNN suffix before the file extension
+func partFileNameFor(fileName string, part int) string {
+	return fmt.Sprintf("%s_part%02d.parquet", strings.TrimSuffix(fileName, ".parquet"), part)
+}
+
+// getWriterOptions returns the parquet writer options derived from the flags
+func getWriterOptions() []parquet.WriterOption {
+	// Files are encoded into memory, so skip the writer's own buffering; this
+	// also keeps the output length current for -target-file-bytes splitting
+	options := append([]parquet.WriterOption{parquet.WriteBufferSize(0)}, compressionOptions...)
+	if *pageSize > 0 {
+		options = append(options, parquet.PageBufferSize(*pageSize))
+	}
+	if *bloomFilter {
+		options = append(options, parquet.BloomFilters(parquet.SplitBlockFilter(*bloomBits, "content_hash")))
+	}
+	return options
+}
+
+// compressionOptions holds the parquet codec chosen by -compression, parsed
+// once at startup so every file shares the same codec (and its encoder pool)
+var compressionOptions []parquet.WriterOption
+
+// parseCompression resolves -compression and -compression-level to writer options
+func parseCompression(name string, level int) ([]parquet.WriterOption, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if level != 0 && name != "zstd" {
+		return nil, fmt.Errorf("-compression-level is only supported with zstd, not %q", name)
+	}
+
+	switch name {
 	case "snappy":
-		return []parquet.WriterOption{parquet.Compression(&parquet.Snappy)}
+		return []parquet.WriterOption{parquet.Compression(&parquet.Snappy)}, nil
 	case "gzip":
-		return []parquet.WriterOption{parquet.Compression(&parquet.Gzip)}
+		return []parquet.WriterOption{parquet.Compression(&parquet.Gzip)}, nil
+	case "zstd":
+		codec := &zstd.Codec{Level: zstd.DefaultLevel}
+		if level != 0 {
+			if level < 1 || level > 22 {
+				return nil, fmt.Errorf("zstd level %d out of range (1-22)", level)
+			}
+			codec.Level = kzstd.EncoderLevelFromZstd(level)
+		}
+		return []parquet.WriterOption{parquet.Compression(codec)}, nil
+	case "lz4":
+		return []parquet.WriterOption{parquet.Compression(&parquet.Lz4Raw)}, nil
 	case "none":
-		return nil
+		return nil, nil
 	default:
-		return []parquet.WriterOption{parquet.Compression(&parquet.Snappy)}
+		return nil, fmt.Errorf("unknown compression %q (expected snappy, gzip, zstd, lz4 or none)", name)
 	}
 }
 
-func parseTimestamp(logLine string) time.Time {
-	// Try JSON timestamp extraction first if it looks like JSON
-	if strings.HasPrefix(logLine, "{") {
-		fields := strings.Split(*timestampFields, ",")
-		for _, field := range fields {
-			field = strings.TrimSpace(field)
-			if field == "" {
-				continue
-			}
+// parseEpoch interprets a numeric Unix timestamp, choosing seconds, millis,
+// micros or nanos by the number of integer digits (10, 13, 16 or 19 for
+// current dates). Fractions are only honored for seconds.
+func parseEpoch(s string) (time.Time, bool) {
+	intPart, frac, _ := strings.Cut(s, ".")
+	n, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
 
-			// Check if field exists
-			if !strings.Contains(logLine, fmt.Sprintf(`"%s"`, field)) {
-				continue
-			}
+	var t time.Time
+	switch digits := len(strings.TrimLeft(intPart, "0")); {
+	case digits <= 10:
+		var nanos int64
+		if frac != "" {
+			f, _ := strconv.ParseFloat("0."+frac, 64)
+			nanos = int64(f * 1e9)
+		}
+		t = time.Unix(n, nanos)
+	case digits <= 13:
+		t = time.UnixMilli(n)
+	case digits <= 16:
+		t = time.UnixMicro(n)
+	default:
+		t = time.Unix(0, n)
+	}
 
-			// Try to extract timestamp value
-			pattern := regexp.MustCompile(fmt.Sprintf(`"%s"\s*:\s*"([^"]+)"`, regexp.QuoteMeta(field)))
-			matches := pattern.FindStringSubmatch(logLine)
-			if len(matches) > 1 {
-				timestampStr := matches[1]
-				// Try common timestamp formats
-				formats := []string{
-					time.RFC3339,
-					time.RFC3339Nano,
-					"2006-01-02T15:04:05",
-					"2006-01-02 15:04:05",
-				}
-				for _, format := range formats {
-					if t, err := time.Parse(format, timestampStr); err == nil {
-						if t.Year() > 2000 && t.Year() < 2100 {
-							return t
-						}
+	// Check the year in UTC so the bounds don't move with the host zone
+	if t = t.UTC(); t.Year() > 2000 && t.Year() < 2100 {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// timestampFormats are the layouts tried for string timestamp fields
+var timestampFormats = []string{
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// timestampFromFields reads the first -timestamp-fields path holding a valid
+// timestamp string or numeric epoch
+func timestampFromFields(fields map[string]interface{}) (time.Time, bool) {
+	for _, path := range strings.Split(currentConfig().timestampFields, ",") {
+		value, ok := lookupField(fields, strings.TrimSpace(path))
+		if !ok {
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			for _, format := range timestampFormats {
+				if t, err := time.Parse(format, v); err == nil {
+					if t.Year() > 2000 && t.Year() < 2100 {
+						return t, true
 					}
 				}
 			}
+		case json.Number:
+			// Numeric epochs in seconds, millis, micros or nanos
+			if t, ok := parseEpoch(v.String()); ok {
+				return t, true
+			}
 		}
 	}
+	return time.Time{}, false
+}
 
-	// Extract timestamp from Apache log format: [Day Mon DD HH:MM:SS YYYY]
-	if strings.Contains(logLine, "[") && strings.Contains(logLine, "]") {
-		start := strings.Index(logLine, "[")
-		end := strings.Index(logLine, "]")
-		if end > start {
-			timestampStr := logLine[start+1 : end]
+// textFieldPatterns caches the compiled textField pattern of each key
+var textFieldPatterns sync.Map
 
-			// Apache log format: Mon Jan 02 15:04:05 2006
-			format := "Mon Jan 02 15:04:05 2006"
-			if t, err := time.Parse(format, timestampStr); err == nil {
-				if t.Year() > 2000 && t.Year() < 2100 {
-					return t
-				}
+func textFieldPattern(key string) *regexp.Regexp {
+	if pattern, ok := textFieldPatterns.Load(key); ok {
+		return pattern.(*regexp.Regexp)
+	}
+	k := regexp.QuoteMeta(key)
+	pattern := regexp.MustCompile(`"` + k + `"\s*:\s*(?:"([^"]*)"|(-?\d+(?:\.\d+)?))|(?:^|[\s,])` + k + `=(?:"([^"]*)"|([^\s"]+))`)
+	textFieldPatterns.Store(key, pattern)
+	return pattern
+}
+
+// timestampFromText reads the first -timestamp-fields key holding a valid
+// timestamp in a line that is not valid JSON, such as logfmt or truncated JSON
+func timestampFromText(line string) (time.Time, bool) {
+	for _, key := range strings.Split(currentConfig().timestampFields, ",") {
+		value, quoted, ok := textField(line, strings.TrimSpace(key))
+		if !ok {
+			continue
+		}
+		for _, format := range timestampFormats {
+			if t, err := time.Parse(format, value); err == nil && t.Year() > 2000 && t.Year() < 2100 {
+				return t, true
+			}
+		}
+		if !quoted {
+			if t, ok := parseEpoch(value); ok {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseTimestamp extracts a timestamp from a log line, reporting whether one was found
+func parseTimestamp(logLine string) (time.Time, bool) {
+	return parseLineTimestamp(logLine, parseJSONFields(logLine))
+}
+
+// bracketTimestampFormats are the Apache/nginx layouts found between square
+// brackets: error logs, then access logs (common and combined)
+var bracketTimestampFormats = []string{
+	"Mon Jan 02 15:04:05 2006",   // also matches Apache 2.4's fractional seconds
+	"02/Jan/2006:15:04:05 -0700", // the offset is kept, not converted
+}
+
+// bracketedTimestamp returns the first [...] span in the line that parses as
+// a bracketTimestampFormats timestamp. Other bracketed text (request lines,
+// JSON arrays, nested brackets) is skipped.
+func bracketedTimestamp(logLine string) (time.Time, bool) {
+	for rest := logLine; ; {
+		start := strings.IndexByte(rest, '[')
+		if start < 0 {
+			return time.Time{}, false
+		}
+		rest = rest[start+1:]
+		end := strings.IndexAny(rest, "[]")
+		if end < 0 {
+			return time.Time{}, false
+		}
+		if rest[end] == '[' {
+			continue // try the inner bracket
+		}
+
+		candidate := rest[:end]
+		for _, format := range bracketTimestampFormats {
+			if t, err := time.Parse(format, candidate); err == nil && t.Year() > 2000 && t.Year() < 2100 {
+				return t, true
 			}
 		}
+		rest = rest[end+1:]
+	}
+}
+
+// parseLineTimestamp extracts a timestamp from a line's decoded JSON fields, or
+// from known text layouts when the line is not JSON (fields is nil)
+func parseLineTimestamp(logLine string, fields map[string]interface{}) (time.Time, bool) {
+	if fields != nil {
+		if t, ok := timestampFromFields(fields); ok {
+			return t, true
+		}
+	} else if t, ok := timestampFromText(logLine); ok {
+		return t, true
+	}
+
+	if t, ok := bracketedTimestamp(logLine); ok {
+		return t, true
 	}
 
 	// Fallback: try other common formats at start of line
@@ -867,12 +2250,11 @@ func parseTimestamp(logLine string) time.Time {
 			potential := logLine[:len(format)]
 			if t, err := time.Parse(format, potential); err == nil {
 				if t.Year() > 2000 && t.Year() < 2100 {
-					return t
+					return t, true
 				}
 			}
 		}
 	}
 
-	// Last resort: use current time
-	return time.Now()
+	return time.Time{}, false
 }
@@ -0,0 +1,452 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestMain(m *testing.M) {
+	*autoFlush = false
+	if err := loadLiveConfig(); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// setLiveFlag sets a live-reloadable flag for the rest of the test and
+// rebuilds the live config from it
+func setLiveFlag(t *testing.T, name, value string) {
+	t.Helper()
+	previous := flag.Lookup(name).Value.String()
+	t.Cleanup(func() {
+		flag.Set(name, previous)
+		loadLiveConfig()
+	})
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadLiveConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// memStorage keeps objects in memory
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{objects: make(map[string][]byte)}
+}
+
+func (m *memStorage) Name() string { return "mem" }
+
+func (m *memStorage) Put(ctx context.Context, key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: not found", key)
+	}
+	return data, nil
+}
+
+func (m *memStorage) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// keys returns the stored keys in order
+func (m *memStorage) keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.objects))
+	for key := range m.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// testEntries returns n entries with distinct messages
+func testEntries(n int) []LogEntry {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := make([]LogEntry, n)
+	for i := range entries {
+		entries[i] = LogEntry{
+			Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+			Level:     "info",
+			Message:   fmt.Sprintf("GET /api/orders/%d 200 %dms", i*7919, i%250),
+		}
+	}
+	return entries
+}
+
+// encodeTestFile encodes entries the way flushed files are
+func encodeTestFile(t *testing.T, entries []LogEntry) []byte {
+	t.Helper()
+	data, err := encodeParquet(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// messagePages returns how many data pages hold the message column of an
+// encoded file
+func messagePages(t *testing.T, data []byte) int64 {
+	t.Helper()
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open parquet: %v", err)
+	}
+	column, ok := file.Schema().Lookup("message")
+	if !ok {
+		t.Fatal("no message column")
+	}
+
+	var pages int64
+	for _, rowGroup := range file.RowGroups() {
+		index, err := rowGroup.ColumnChunks()[column.ColumnIndex].OffsetIndex()
+		if err != nil {
+			t.Fatalf("offset index: %v", err)
+		}
+		pages += int64(index.NumPages())
+	}
+	return pages
+}
+
+func TestPageSize(t *testing.T) {
+	defer func(size int) { *pageSize = size }(*pageSize)
+	entries := testEntries(20000)
+
+	*pageSize = 0
+	defaultPages := messagePages(t, encodeTestFile(t, entries))
+
+	*pageSize = 8 << 10
+	smallPages := messagePages(t, encodeTestFile(t, entries))
+
+	if smallPages <= defaultPages {
+		t.Errorf("-page-size 8192 wrote %d message pages, want more than the default's %d", smallPages, defaultPages)
+	}
+}
+
+func TestLastSubmatch(t *testing.T) {
+	pattern := regexp.MustCompile(`level=(\w+)`)
+
+	value, pos := lastSubmatch(pattern, "level=info msg=retry level=error")
+	if value != "error" || pos != 21 {
+		t.Errorf("got %q at %d, want \"error\" at 21", value, pos)
+	}
+	if value, pos := lastSubmatch(pattern, "msg=ok"); value != "" || pos != -1 {
+		t.Errorf("no match: got %q at %d, want \"\" at -1", value, pos)
+	}
+}
+
+func TestDuplicateLevelKeyLastWins(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`{"level":"info","msg":"retry","level":"error"}`, "error"},
+		{`{"level":"error","level":"debug"}`, "debug"},
+		{`{"level":"warn","level":3}`, "debug"},
+		{`{"level":17,"level":"warning"}`, "warn"},
+	}
+	for _, tt := range tests {
+		if got := extractLevel(tt.line, parseJSONFields(tt.line)); got != tt.want {
+			t.Errorf("extractLevel(%s) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicateKeysThroughProcessLine(t *testing.T) {
+	defer func(enabled bool, local *time.Location) {
+		*logTimestamps, time.Local = enabled, local
+	}(*logTimestamps, time.Local)
+	*logTimestamps = true
+	time.Local = time.UTC // pin the local-time date= partition
+
+	storage := newMemStorage()
+	ingestor := NewLogIngestor(storage)
+	line := `{"timestamp":"2023-01-01T00:00:00Z","level":"info","msg":"replayed","timestamp":"2024-05-06T12:08:09Z","level":"error"}`
+	if err := ingestor.ProcessLine(line); err != nil {
+		t.Fatal(err)
+	}
+	if err := ingestor.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, key := range storage.keys() {
+		if strings.HasSuffix(key, ".parquet") {
+			found = true
+			if !strings.Contains(key, "/date=2024-05-06/level=error/") {
+				t.Errorf("wrote %s, want it under the last timestamp and level", key)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no parquet file written, objects %q", storage.keys())
+	}
+}
+
+func TestDuplicateTimestampKeyLastWins(t *testing.T) {
+	line := `{"timestamp":"2023-01-01T00:00:00Z","msg":"replayed","timestamp":"2024-05-06T07:08:09Z"}`
+	want := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	if got, ok := parseTimestamp(line); !ok || !got.Equal(want) {
+		t.Errorf("parseTimestamp = %v, %v; want %v", got, ok, want)
+	}
+}
+
+func TestEmptyBatchWritesNothing(t *testing.T) {
+	defer func(size int64) { *targetFileBytes = size }(*targetFileBytes)
+
+	for _, target := range []int64{0, 1 << 20} {
+		*targetFileBytes = target
+		files, err := encodePartitionFiles(nil)
+		if err != nil || len(files) != 0 {
+			t.Errorf("-target-file-bytes %d: encodePartitionFiles(nil) = %d files, %v; want none", target, len(files), err)
+		}
+	}
+
+	storage := newMemStorage()
+	files, err := flushBatch(&BatchInfo{StartTime: time.Now(), EndTime: time.Now()}, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 || len(storage.keys()) != 0 {
+		t.Errorf("empty batch wrote %d files, objects %q", len(files), storage.keys())
+	}
+}
+
+func TestDedupCacheStaysBounded(t *testing.T) {
+	const window = 1000
+	cache := NewDedupCache(window)
+
+	const adds = 3_000_000
+	for i := 0; i < adds; i++ {
+		cache.Add(strconv.Itoa(i))
+	}
+
+	if got := cache.Size(); got != window {
+		t.Errorf("Size() = %d after %d adds, want %d", got, adds, window)
+	}
+	if len(cache.order) != window || cap(cache.order) != window {
+		t.Errorf("ring buffer len %d cap %d, want %d", len(cache.order), cap(cache.order), window)
+	}
+	if !cache.Contains(strconv.Itoa(adds-window)) || !cache.Contains(strconv.Itoa(adds-1)) {
+		t.Error("the newest window of hashes should be kept")
+	}
+	if cache.Contains(strconv.Itoa(adds - window - 1)) {
+		t.Error("hashes older than the window should be evicted")
+	}
+
+	// Re-adding a cached hash must not take a second slot
+	cache.Add(strconv.Itoa(adds - 1))
+	cache.Add("new")
+	if !cache.Contains(strconv.Itoa(adds-window+1)) || cache.Contains(strconv.Itoa(adds-window)) {
+		t.Error("one new hash should evict exactly the oldest")
+	}
+}
+
+func TestNumericSeverityLevels(t *testing.T) {
+	tests := []struct {
+		severity int
+		want     string
+	}{
+		{4, "debug"},
+		{9, "warn"},
+		{10, "warn"}, // compared as strings, "10" fell in the debug range
+		{13, "error"},
+		{17, "error"},
+		{24, "error"},
+	}
+	for _, tt := range tests {
+		line := fmt.Sprintf(`{"severity":%d,"message":"disk usage high"}`, tt.severity)
+		if got := extractLevel(line, parseJSONFields(line)); got != tt.want {
+			t.Errorf("severity %d: got %q, want %q", tt.severity, got, tt.want)
+		}
+	}
+}
+
+func TestParsePartitionBy(t *testing.T) {
+	dims, err := parsePartitionBy(" date, hour,,service ")
+	if err != nil || !slices.Equal(dims, []string{"date", "hour", "service"}) {
+		t.Errorf("got %q, %v", dims, err)
+	}
+	for _, spec := range []string{"date,week", "date,level,date"} {
+		if _, err := parsePartitionBy(spec); err == nil {
+			t.Errorf("parsePartitionBy(%q) should fail", spec)
+		}
+	}
+}
+
+func TestGetPartitionKeyDimensions(t *testing.T) {
+	defer func(dims []string, local *time.Location) {
+		partitionDims, time.Local = dims, local
+	}(partitionDims, time.Local)
+
+	// date= and hour= are in local time, whatever offset the entry carries
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	entry := LogEntry{
+		Timestamp: time.Date(2026, 1, 2, 1, 4, 5, 0, time.UTC),
+		Level:     "error",
+		Service:   "billing/api",
+	}
+	tests := []struct {
+		dims []string
+		want string
+	}{
+		{[]string{"date", "level"}, "date=2026-01-02/level=error"},
+		{[]string{"service", "date", "hour"}, "service=billing_api/date=2026-01-02/hour=03"},
+		{[]string{"level", "service"}, "level=error/service=billing_api"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		partitionDims = tt.dims
+		if got := GetPartitionKey(entry); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.dims, got, tt.want)
+		}
+	}
+
+	// Unknown levels and missing services leave their dimension out
+	partitionDims = []string{"date", "level", "service"}
+	if got := GetPartitionKey(LogEntry{Timestamp: entry.Timestamp, Level: "unknown"}); got != "date=2026-01-02" {
+		t.Errorf("got %q, want only the date", got)
+	}
+}
+
+func TestParseEpoch(t *testing.T) {
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) // 1767323045
+
+	tests := []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"1767323045", base, true},
+		{"1767323045.25", base.Add(250 * time.Millisecond), true},
+		{"1767323045123", base.Add(123 * time.Millisecond), true},
+		{"1767323045123456", base.Add(123456 * time.Microsecond), true},
+		{"1767323045123456789", base.Add(123456789), true},
+		{"01767323045", base, true}, // leading zeros don't count as digits
+
+		// Seconds/millis boundary: 10 digits are seconds, 11 or more millis
+		{"4102444799", time.Date(2099, 12, 31, 23, 59, 59, 0, time.UTC), true},
+		{"9999999999", time.Time{}, false},  // seconds in 2286
+		{"99999999999", time.Time{}, false}, // millis in 1973
+		{"1000000000000", time.Date(2001, 9, 9, 1, 46, 40, 0, time.UTC), true},
+
+		{"978307199", time.Time{}, false}, // 2000-12-31, before the accepted range
+		{"not-a-number", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseEpoch(tt.in)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseEpoch(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAccessLogTimestamps(t *testing.T) {
+	plus2 := time.FixedZone("", 2*60*60)
+	minus7 := time.FixedZone("", -7*60*60)
+
+	tests := []struct {
+		name string
+		line string
+		want time.Time
+	}{
+		{
+			"nginx combined",
+			`203.0.113.7 - - [10/Oct/2023:13:55:36 +0200] "GET /index.html HTTP/1.1" 200 2326 "https://example.com/" "Mozilla/5.0 (X11; Linux x86_64)"`,
+			time.Date(2023, 10, 10, 13, 55, 36, 0, plus2),
+		},
+		{
+			"apache combined with a user",
+			`198.51.100.23 - frank [05/Mar/2024:08:12:01 -0700] "POST /login HTTP/1.1" 302 - "-" "curl/8.4.0"`,
+			time.Date(2024, 3, 5, 8, 12, 1, 0, minus7),
+		},
+		{
+			"bracketed IPv6 host before the timestamp",
+			`[2001:db8::1] - - [10/Oct/2023:13:55:36 +0200] "GET / HTTP/1.1" 200 612 "-" "-"`,
+			time.Date(2023, 10, 10, 13, 55, 36, 0, plus2),
+		},
+		{
+			"brackets in the request line",
+			`203.0.113.7 - - [10/Oct/2023:13:55:36 +0200] "GET /search?q=[a] HTTP/1.1" 200 51 "-" "-"`,
+			time.Date(2023, 10, 10, 13, 55, 36, 0, plus2),
+		},
+		{
+			"apache error log",
+			`[Wed Oct 11 14:32:52 2023] [error] [client 203.0.113.7] File does not exist: /var/www/favicon.ico`,
+			time.Date(2023, 10, 11, 14, 32, 52, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Compare in RFC3339 so the preserved offset is checked too
+			got, ok := parseTimestamp(tt.line)
+			if !ok || got.Format(time.RFC3339) != tt.want.Format(time.RFC3339) {
+				t.Errorf("parseTimestamp = %v, %v; want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartitionAsColumn(t *testing.T) {
+	defer func(dims []string, enabled, timestamps bool) {
+		partitionDims, *partitionAsColumn, *logTimestamps = dims, enabled, timestamps
+	}(partitionDims, *partitionAsColumn, *logTimestamps)
+	*partitionAsColumn, *logTimestamps = true, true
+
+	ingestor := NewLogIngestor(newMemStorage())
+	line := `{"timestamp":"2026-01-02T12:04:05Z","level":"error","service":"billing/api","msg":"declined"}`
+
+	partitionDims = []string{"service", "date", "hour", "level"}
+	entry, ok := ingestor.buildEntry(line)
+	if !ok {
+		t.Fatal("entry not built")
+	}
+	if entry.ServiceName != "billing_api" || entry.Date == "" || entry.Hour == "" {
+		t.Errorf("got date %q, hour %q, service %q; want all set", entry.Date, entry.Hour, entry.ServiceName)
+	}
+	want := fmt.Sprintf("service=%s/date=%s/hour=%s/level=error", entry.ServiceName, entry.Date, entry.Hour)
+	if got := GetPartitionKey(entry); got != want {
+		t.Errorf("partition key %q does not match the columns %q", got, want)
+	}
+
+	// Only the active dimensions get a column
+	partitionDims = []string{"date", "level"}
+	entry, _ = ingestor.buildEntry(line)
+	if entry.Date == "" || entry.Hour != "" || entry.ServiceName != "" {
+		t.Errorf("got date %q, hour %q, service %q; want only the date", entry.Date, entry.Hour, entry.ServiceName)
+	}
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BatchManifest lists the files written by one flushed batch so readers can
+// prune by time range and level without opening every parquet file
+type BatchManifest struct {
+	Batch   int            `json:"batch"`
+	Created time.Time      `json:"created"`
+	Files   []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes one parquet file in a batch
+type ManifestFile struct {
+	Key          string    `json:"key"`
+	Partition    string    `json:"partition,omitempty"` // empty for combined and unpartitioned files
+	Rows         int       `json:"rows"`
+	Bytes        int       `json:"bytes"`
+	MinTimestamp time.Time `json:"min_timestamp"`
+	MaxTimestamp time.Time `json:"max_timestamp"`
+	Levels       []string  `json:"levels"`
+	Spilled      bool      `json:"spilled,omitempty"` // in -spill-dir until -drain-spill uploads it
+
+	groups []string // partition groups with entries in the file
+}
+
+// newManifestFile summarizes the entries written to key
+func newManifestFile(key, partition string, entries []LogEntry, size int) ManifestFile {
+	file := ManifestFile{Key: key, Partition: partition, Rows: len(entries), Bytes: size}
+	levels := make(map[string]bool)
+	for i, entry := range entries {
+		if i == 0 || entry.Timestamp.Before(file.MinTimestamp) {
+			file.MinTimestamp = entry.Timestamp
+		}
+		if i == 0 || entry.Timestamp.After(file.MaxTimestamp) {
+			file.MaxTimestamp = entry.Timestamp
+		}
+		levels[entry.Level] = true
+	}
+	for level := range levels {
+		file.Levels = append(file.Levels, level)
+	}
+	sort.Strings(file.Levels)
+	return file
+}
+
+// writeManifest stores a batch manifest as <prefix>/_manifests/<batch file>.json.
+// Failures are only logged: the data files are already written and stay
+// readable without it.
+func writeManifest(storage Storage, baseFileName string, batchNumber int, files []ManifestFile) {
+	if len(files) == 0 {
+		return
+	}
+
+	manifest := BatchManifest{Batch: batchNumber, Created: time.Now().UTC(), Files: files}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		log.Printf("Error encoding manifest for batch %d: %v", batchNumber, err)
+		return
+	}
+
+	key := fmt.Sprintf("%s/_manifests/%s.json", *prefix, strings.TrimSuffix(baseFileName, ".parquet"))
+	if err := storage.Put(context.TODO(), key, data); err != nil {
+		log.Printf("Error writing manifest %s (data files were written): %v", key, err)
+	}
+}
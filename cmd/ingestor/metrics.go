@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BackendMetrics counts writes to a single storage backend, labelled by
+// backend type and bucket (or directory for the local backend)
+type BackendMetrics struct {
+	Backend string
+	Bucket  string
+	writes  atomic.Int64
+	errors  atomic.Int64
+	bytes   atomic.Int64
+}
+
+// BackendMetricsSnapshot is a point-in-time copy of BackendMetrics
+type BackendMetricsSnapshot struct {
+	Backend      string `json:"backend"`
+	Bucket       string `json:"bucket"`
+	Writes       int64  `json:"writes"`
+	Errors       int64  `json:"errors"`
+	BytesWritten int64  `json:"bytes_written"`
+}
+
+var (
+	backendMetricsMu sync.Mutex
+	backendMetrics   []*BackendMetrics
+)
+
+// registerBackendMetrics creates and registers the counters for a backend
+func registerBackendMetrics(backend, bucket string) *BackendMetrics {
+	backendMetricsMu.Lock()
+	defer backendMetricsMu.Unlock()
+
+	m := &BackendMetrics{Backend: backend, Bucket: bucket}
+	backendMetrics = append(backendMetrics, m)
+	return m
+}
+
+// backendMetricsSnapshot returns the current counters of every backend
+func backendMetricsSnapshot() []BackendMetricsSnapshot {
+	backendMetricsMu.Lock()
+	defer backendMetricsMu.Unlock()
+
+	snapshot := make([]BackendMetricsSnapshot, len(backendMetrics))
+	for i, m := range backendMetrics {
+		snapshot[i] = BackendMetricsSnapshot{
+			Backend:      m.Backend,
+			Bucket:       m.Bucket,
+			Writes:       m.writes.Load(),
+			Errors:       m.errors.Load(),
+			BytesWritten: m.bytes.Load(),
+		}
+	}
+	return snapshot
+}
+
+// instrumentedStorage records per-backend metrics around another backend's writes
+type instrumentedStorage struct {
+	Storage
+	metrics *BackendMetrics
+}
+
+func newInstrumentedStorage(storage Storage, backend, bucket string) *instrumentedStorage {
+	return &instrumentedStorage{
+		Storage: storage,
+		metrics: registerBackendMetrics(backend, bucket),
+	}
+}
+
+func (s *instrumentedStorage) Put(ctx context.Context, key string, data []byte) error {
+	if err := s.Storage.Put(ctx, key, data); err != nil {
+		s.metrics.errors.Add(1)
+		return err
+	}
+	s.metrics.writes.Add(1)
+	s.metrics.bytes.Add(int64(len(data)))
+	return nil
+}
+
+// flushDurationBuckets are the histogram upper bounds, in seconds
+var flushDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Histogram is a cumulative Prometheus-style histogram
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []int64 // per bucket, plus +Inf
+	sum     float64
+	count   int64
+}
+
+// NewHistogram creates a histogram with the given upper bounds
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]int64, len(buckets)+1)}
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := 0
+	for i < len(h.buckets) && v > h.buckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// flushDurations times every batch flush, successful or not
+var flushDurations = NewHistogram(flushDurationBuckets)
+
+// observeFlush records how long a flush took
+func observeFlush(start time.Time) {
+	flushDurations.Observe(time.Since(start).Seconds())
+}
+
+// writePrometheusMetrics writes ingestor and backend metrics in the Prometheus
+// text exposition format
+func writePrometheusMetrics(w io.Writer, li *LogIngestor, gelfQueue *GELFQueue) {
+	lineCount, partitionCount, duplicateCount, uniqueCount := li.GetStats()
+
+	li.mu.Lock()
+	batches, files, spilled, flushErrors := li.batchesFlushed, li.filesWritten, li.filesSpilled, li.flushErrors
+	li.mu.Unlock()
+
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("blobsearch_lines_total", "counter", "Lines received, including duplicates.", lineCount)
+	metric("blobsearch_unique_lines_total", "counter", "Lines accepted after deduplication.", uniqueCount)
+	metric("blobsearch_duplicates_total", "counter", "Lines skipped as duplicates.", duplicateCount)
+	metric("blobsearch_partitions", "gauge", "Partitions tracked since startup.", partitionCount)
+	metric("blobsearch_buffered_entries", "gauge", "Entries waiting to be flushed.", li.bufferedEntries())
+	metric("blobsearch_batches_flushed_total", "counter", "Batches flushed to storage.", batches)
+	metric("blobsearch_files_written_total", "counter", "Parquet files written.", files)
+	metric("blobsearch_files_spilled_total", "counter", "Parquet files saved to -spill-dir instead of storage.", spilled)
+	metric("blobsearch_flush_errors_total", "counter", "Batch flushes that failed.", flushErrors)
+	metric("blobsearch_gelf_queue_depth", "gauge", "GELF TCP messages waiting to be processed.", gelfQueue.Depth())
+	metric("blobsearch_gelf_queue_capacity", "gauge", "Maximum GELF TCP queue depth.", gelfQueue.Capacity())
+	metric("blobsearch_gelf_queue_stalls_total", "counter", "Times a GELF TCP reader waited for queue space.", gelfQueue.Stalls())
+
+	backends := backendMetricsSnapshot()
+	for _, m := range []struct {
+		name, help string
+		value      func(BackendMetricsSnapshot) int64
+	}{
+		{"blobsearch_backend_writes_total", "Objects written per storage backend.", func(b BackendMetricsSnapshot) int64 { return b.Writes }},
+		{"blobsearch_backend_write_errors_total", "Failed writes per storage backend.", func(b BackendMetricsSnapshot) int64 { return b.Errors }},
+		{"blobsearch_backend_bytes_written_total", "Bytes written per storage backend.", func(b BackendMetricsSnapshot) int64 { return b.BytesWritten }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+		for _, b := range backends {
+			fmt.Fprintf(w, "%s{backend=%q,bucket=%q} %d\n", m.name, b.Backend, b.Bucket, m.value(b))
+		}
+	}
+
+	if multi, ok := multiStorageOf(li.storage); ok {
+		metric("blobsearch_partial_writes_total", "counter", "Writes that met -backend-quorum but failed on at least one backend.", multi.PartialWrites())
+	}
+
+	// One series per state, 1 for the current one (the Prometheus enum convention)
+	if guarded, ok := unwrapSpill(li.storage).(*breakerStorage); ok {
+		const name = "blobsearch_breaker_state"
+		fmt.Fprintf(w, "# HELP %s Storage circuit breaker state.\n# TYPE %s gauge\n", name, name)
+		current := guarded.BreakerState()
+		for _, state := range []string{breakerClosed, breakerHalfOpen, breakerOpen} {
+			value := 0
+			if state == current {
+				value = 1
+			}
+			fmt.Fprintf(w, "%s{backend=%q,state=%q} %d\n", name, guarded.Name(), state, value)
+		}
+	}
+
+	h := flushDurations
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	const name = "blobsearch_flush_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Time to encode and write a batch.\n# TYPE %s histogram\n", name, name)
+	var cumulative int64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
@@ -0,0 +1,436 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// OTLP ExportLogsServiceRequest, reduced to the fields we store. The JSON tags
+// follow the OTLP/JSON encoding; the protobuf encoding is decoded into the
+// same types by decodeOTLPProto.
+type otlpRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpScopeLogs struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         json.Number    `json:"timeUnixNano"`
+	ObservedTimeUnixNano json.Number    `json:"observedTimeUnixNano"`
+	SeverityNumber       int32          `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 *otlpAnyValue  `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+	TraceID              string         `json:"traceId"` // hex, as in OTLP/JSON
+	SpanID               string         `json:"spanId"`
+	EventName            string         `json:"eventName"`
+}
+
+type otlpKeyValue struct {
+	Key   string        `json:"key"`
+	Value *otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string      `json:"stringValue"`
+	BoolValue   *bool        `json:"boolValue"`
+	IntValue    *json.Number `json:"intValue"`
+	DoubleValue *json.Number `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []otlpAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+	BytesValue []byte `json:"bytesValue"`
+}
+
+// value converts an AnyValue to the equivalent JSON value; bytes are base64
+func (v *otlpAnyValue) value() interface{} {
+	switch {
+	case v == nil:
+		return nil
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.ArrayValue != nil:
+		values := make([]interface{}, len(v.ArrayValue.Values))
+		for i := range v.ArrayValue.Values {
+			values[i] = v.ArrayValue.Values[i].value()
+		}
+		return values
+	case v.KvlistValue != nil:
+		return otlpAttributes(v.KvlistValue.Values)
+	case v.BytesValue != nil:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	}
+	return nil
+}
+
+// otlpAttributes flattens a KeyValue list into a map keyed by attribute name
+func otlpAttributes(attributes []otlpKeyValue) map[string]interface{} {
+	if len(attributes) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(attributes))
+	for _, kv := range attributes {
+		m[kv.Key] = kv.Value.value()
+	}
+	return m
+}
+
+// otlpLines flattens every LogRecord into the OpenTelemetry-shaped JSON line
+// the generator emits, carrying its resource and scope along
+func otlpLines(request otlpRequest) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, resourceLogs := range request.ResourceLogs {
+		resource := otlpAttributes(resourceLogs.Resource.Attributes)
+		for _, scopeLogs := range resourceLogs.ScopeLogs {
+			for _, record := range scopeLogs.LogRecords {
+				line := map[string]interface{}{"body": record.Body.value()}
+				if t, ok := otlpTime(record.TimeUnixNano); ok {
+					line["timestamp"] = t.Format(time.RFC3339Nano)
+				} else if t, ok := otlpTime(record.ObservedTimeUnixNano); ok {
+					line["timestamp"] = t.Format(time.RFC3339Nano)
+				}
+				if t, ok := otlpTime(record.ObservedTimeUnixNano); ok {
+					line["observedTimestamp"] = t.Format(time.RFC3339Nano)
+				}
+				if record.SeverityNumber > 0 {
+					line["severityNumber"] = record.SeverityNumber
+					if record.SeverityText == "" {
+						// Let the default -level-fields map the number
+						line["severity"] = record.SeverityNumber
+					}
+				}
+				if record.SeverityText != "" {
+					line["severityText"] = record.SeverityText
+				}
+				if record.TraceID != "" {
+					line["traceId"] = record.TraceID
+				}
+				if record.SpanID != "" {
+					line["spanId"] = record.SpanID
+				}
+				if record.EventName != "" {
+					line["eventName"] = record.EventName
+				}
+				if resource != nil {
+					line["resource"] = resource
+				}
+				if attributes := otlpAttributes(record.Attributes); attributes != nil {
+					line["attributes"] = attributes
+				}
+				if scopeLogs.Scope.Name != "" {
+					line["scope"] = map[string]interface{}{"name": scopeLogs.Scope.Name, "version": scopeLogs.Scope.Version}
+				}
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// otlpTime converts a unix-nanosecond timestamp; 0 means unset
+func otlpTime(n json.Number) (time.Time, bool) {
+	nanos, err := strconv.ParseUint(n.String(), 10, 64)
+	if err != nil || nanos == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(nanos)).UTC(), true
+}
+
+// decodeOTLPJSON decodes an OTLP/JSON ExportLogsServiceRequest
+func decodeOTLPJSON(data []byte) (otlpRequest, error) {
+	var request otlpRequest
+	err := json.Unmarshal(data, &request)
+	return request, err
+}
+
+// decodeOTLPProto decodes a protobuf ExportLogsServiceRequest
+func decodeOTLPProto(data []byte) (otlpRequest, error) {
+	var request otlpRequest
+	err := protoFields(data, func(num protowire.Number, field protoField) error {
+		if num == 1 && field.typ == protowire.BytesType {
+			var resourceLogs otlpResourceLogs
+			if err := decodeResourceLogs(field.bytes, &resourceLogs); err != nil {
+				return err
+			}
+			request.ResourceLogs = append(request.ResourceLogs, resourceLogs)
+		}
+		return nil
+	})
+	return request, err
+}
+
+// protoField is one decoded field: bytes for length-delimited fields,
+// scalar for varint and fixed-width ones
+type protoField struct {
+	typ    protowire.Type
+	bytes  []byte
+	scalar uint64
+}
+
+// protoFields calls fn for each field of a protobuf message. Unknown fields
+// are the caller's to ignore.
+func protoFields(data []byte, fn func(protowire.Number, protoField) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		field := protoField{typ: typ}
+		switch typ {
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			field.scalar, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			field.scalar, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(data)
+			field.scalar = uint64(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeResourceLogs(data []byte, resourceLogs *otlpResourceLogs) error {
+	return protoFields(data, func(num protowire.Number, field protoField) error {
+		if field.typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1: // resource
+			return protoFields(field.bytes, func(num protowire.Number, field protoField) error {
+				if num == 1 && field.typ == protowire.BytesType {
+					return appendKeyValue(field.bytes, &resourceLogs.Resource.Attributes)
+				}
+				return nil
+			})
+		case 2: // scope_logs
+			var scopeLogs otlpScopeLogs
+			if err := decodeScopeLogs(field.bytes, &scopeLogs); err != nil {
+				return err
+			}
+			resourceLogs.ScopeLogs = append(resourceLogs.ScopeLogs, scopeLogs)
+		}
+		return nil
+	})
+}
+
+func decodeScopeLogs(data []byte, scopeLogs *otlpScopeLogs) error {
+	return protoFields(data, func(num protowire.Number, field protoField) error {
+		if field.typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1: // scope
+			return protoFields(field.bytes, func(num protowire.Number, field protoField) error {
+				switch {
+				case num == 1 && field.typ == protowire.BytesType:
+					scopeLogs.Scope.Name = string(field.bytes)
+				case num == 2 && field.typ == protowire.BytesType:
+					scopeLogs.Scope.Version = string(field.bytes)
+				}
+				return nil
+			})
+		case 2: // log_records
+			var record otlpLogRecord
+			if err := decodeLogRecord(field.bytes, &record); err != nil {
+				return err
+			}
+			scopeLogs.LogRecords = append(scopeLogs.LogRecords, record)
+		}
+		return nil
+	})
+}
+
+func decodeLogRecord(data []byte, record *otlpLogRecord) error {
+	return protoFields(data, func(num protowire.Number, field protoField) error {
+		switch {
+		case num == 1 && field.typ == protowire.Fixed64Type:
+			record.TimeUnixNano = json.Number(strconv.FormatUint(field.scalar, 10))
+		case num == 11 && field.typ == protowire.Fixed64Type:
+			record.ObservedTimeUnixNano = json.Number(strconv.FormatUint(field.scalar, 10))
+		case num == 2 && field.typ == protowire.VarintType:
+			record.SeverityNumber = int32(field.scalar)
+		case num == 3 && field.typ == protowire.BytesType:
+			record.SeverityText = string(field.bytes)
+		case num == 5 && field.typ == protowire.BytesType:
+			record.Body = &otlpAnyValue{}
+			return decodeAnyValue(field.bytes, record.Body)
+		case num == 6 && field.typ == protowire.BytesType:
+			return appendKeyValue(field.bytes, &record.Attributes)
+		case num == 9 && field.typ == protowire.BytesType:
+			record.TraceID = hex.EncodeToString(field.bytes)
+		case num == 10 && field.typ == protowire.BytesType:
+			record.SpanID = hex.EncodeToString(field.bytes)
+		case num == 12 && field.typ == protowire.BytesType:
+			record.EventName = string(field.bytes)
+		}
+		return nil
+	})
+}
+
+func appendKeyValue(data []byte, list *[]otlpKeyValue) error {
+	var kv otlpKeyValue
+	err := protoFields(data, func(num protowire.Number, field protoField) error {
+		if field.typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			kv.Key = string(field.bytes)
+		case 2:
+			kv.Value = &otlpAnyValue{}
+			return decodeAnyValue(field.bytes, kv.Value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*list = append(*list, kv)
+	return nil
+}
+
+func decodeAnyValue(data []byte, value *otlpAnyValue) error {
+	return protoFields(data, func(num protowire.Number, field protoField) error {
+		switch {
+		case num == 1 && field.typ == protowire.BytesType:
+			s := string(field.bytes)
+			value.StringValue = &s
+		case num == 2 && field.typ == protowire.VarintType:
+			b := field.scalar != 0
+			value.BoolValue = &b
+		case num == 3 && field.typ == protowire.VarintType:
+			n := json.Number(strconv.FormatInt(int64(field.scalar), 10))
+			value.IntValue = &n
+		case num == 4 && field.typ == protowire.Fixed64Type:
+			f := math.Float64frombits(field.scalar)
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				// JSON has no NaN or Inf, so keep them as text
+				s := strconv.FormatFloat(f, 'g', -1, 64)
+				value.StringValue = &s
+				return nil
+			}
+			n := json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+			value.DoubleValue = &n
+		case num == 5 && field.typ == protowire.BytesType:
+			value.ArrayValue = &struct {
+				Values []otlpAnyValue `json:"values"`
+			}{}
+			return protoFields(field.bytes, func(num protowire.Number, field protoField) error {
+				if num == 1 && field.typ == protowire.BytesType {
+					var element otlpAnyValue
+					if err := decodeAnyValue(field.bytes, &element); err != nil {
+						return err
+					}
+					value.ArrayValue.Values = append(value.ArrayValue.Values, element)
+				}
+				return nil
+			})
+		case num == 6 && field.typ == protowire.BytesType:
+			value.KvlistValue = &struct {
+				Values []otlpKeyValue `json:"values"`
+			}{}
+			return protoFields(field.bytes, func(num protowire.Number, field protoField) error {
+				if num == 1 && field.typ == protowire.BytesType {
+					return appendKeyValue(field.bytes, &value.KvlistValue.Values)
+				}
+				return nil
+			})
+		case num == 7 && field.typ == protowire.BytesType:
+			value.BytesValue = append([]byte{}, field.bytes...)
+		}
+		return nil
+	})
+}
+
+// otlpResponse encodes an ExportLogsServiceResponse, with a partial_success
+// when records were rejected
+func otlpResponse(protobuf bool, rejected int64, message string) []byte {
+	if protobuf {
+		if rejected == 0 {
+			return nil
+		}
+		var partial []byte
+		partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+		partial = protowire.AppendVarint(partial, uint64(rejected))
+		partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+		partial = protowire.AppendString(partial, message)
+
+		var response []byte
+		response = protowire.AppendTag(response, 1, protowire.BytesType)
+		return protowire.AppendBytes(response, partial)
+	}
+
+	response := map[string]interface{}{}
+	if rejected > 0 {
+		response["partialSuccess"] = map[string]interface{}{
+			"rejectedLogRecords": strconv.FormatInt(rejected, 10),
+			"errorMessage":       message,
+		}
+	}
+	data, _ := json.Marshal(response)
+	return data
+}
+
+// ProcessOTLP ingests the records of an export request and reports how many
+// were rejected, with the last rejection's error
+func (li *LogIngestor) ProcessOTLP(request otlpRequest) (accepted, rejected int64, lastErr error) {
+	for _, line := range otlpLines(request) {
+		jsonBytes, err := json.Marshal(line)
+		if err == nil {
+			err = li.ProcessLine(string(jsonBytes))
+		}
+		if err != nil {
+			rejected++
+			lastErr = fmt.Errorf("log record rejected: %w", err)
+			continue
+		}
+		accepted++
+	}
+	return accepted, rejected, lastErr
+}
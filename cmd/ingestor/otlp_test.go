@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoMessage appends length-delimited fields, in order, as one message
+func protoMessage(fields ...func([]byte) []byte) []byte {
+	var b []byte
+	for _, field := range fields {
+		b = field(b)
+	}
+	return b
+}
+
+func protoBytes(num protowire.Number, value []byte) func([]byte) []byte {
+	return func(b []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, value)
+	}
+}
+
+func protoVarint(num protowire.Number, value uint64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, value)
+	}
+}
+
+func protoFixed64(num protowire.Number, value uint64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		return protowire.AppendFixed64(b, value)
+	}
+}
+
+// protoKeyValue encodes a KeyValue whose AnyValue holds one field
+func protoKeyValue(key string, value func([]byte) []byte) []byte {
+	return protoMessage(protoBytes(1, []byte(key)), protoBytes(2, protoMessage(value)))
+}
+
+// otlpTestProto is the protobuf encoding of the request in otlpTestJSON
+func otlpTestProto() []byte {
+	resource := protoMessage(protoBytes(1, protoKeyValue("service.name", protoBytes(1, []byte("billing")))))
+	scope := protoMessage(protoBytes(1, []byte("app.logger")), protoBytes(2, []byte("1.2.0")))
+
+	array := protoMessage(protoBytes(1, protoMessage(protoVarint(3, 1))), protoBytes(1, protoMessage(protoBytes(1, []byte("two")))))
+	kvlist := protoMessage(protoBytes(1, protoKeyValue("inner", protoVarint(2, 1))))
+	record := protoMessage(
+		protoFixed64(1, 1767323045123456789),
+		protoFixed64(11, 1767323046000000000),
+		protoVarint(2, 17),
+		protoBytes(5, protoMessage(protoBytes(1, []byte("payment failed")))),
+		protoBytes(6, protoKeyValue("http.status_code", protoVarint(3, 503))),
+		protoBytes(6, protoKeyValue("retry", protoVarint(2, 1))),
+		protoBytes(6, protoKeyValue("ratio", protoFixed64(4, math.Float64bits(0.25)))),
+		protoBytes(6, protoKeyValue("list", protoBytes(5, array))),
+		protoBytes(6, protoKeyValue("map", protoBytes(6, kvlist))),
+		protoBytes(6, protoKeyValue("raw", protoBytes(7, []byte{0xde, 0xad}))),
+		protoBytes(9, []byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c}),
+		protoBytes(10, []byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74}),
+		protoBytes(12, []byte("payment.failed")),
+		protoVarint(99, 1), // unknown fields are skipped
+	)
+	scopeLogs := protoMessage(protoBytes(1, scope), protoBytes(2, record))
+	resourceLogs := protoMessage(protoBytes(1, resource), protoBytes(2, scopeLogs))
+	return protoMessage(protoBytes(1, resourceLogs))
+}
+
+const otlpTestJSON = `{"resourceLogs":[{
+	"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"billing"}}]},
+	"scopeLogs":[{
+		"scope":{"name":"app.logger","version":"1.2.0"},
+		"logRecords":[{
+			"timeUnixNano":"1767323045123456789",
+			"observedTimeUnixNano":"1767323046000000000",
+			"severityNumber":17,
+			"body":{"stringValue":"payment failed"},
+			"attributes":[
+				{"key":"http.status_code","value":{"intValue":"503"}},
+				{"key":"retry","value":{"boolValue":true}},
+				{"key":"ratio","value":{"doubleValue":0.25}},
+				{"key":"list","value":{"arrayValue":{"values":[{"intValue":"1"},{"stringValue":"two"}]}}},
+				{"key":"map","value":{"kvlistValue":{"values":[{"key":"inner","value":{"boolValue":true}}]}}},
+				{"key":"raw","value":{"bytesValue":"3q0="}}
+			],
+			"traceId":"5b8efff798038103d269b633813fc60c",
+			"spanId":"eee19b7ec3c1b174",
+			"eventName":"payment.failed"
+		}]
+	}]
+}]}`
+
+// otlpTestLine is the line both encodings of the test request flatten to
+var otlpTestLine = map[string]interface{}{
+	"timestamp":         "2026-01-02T03:04:05.123456789Z",
+	"observedTimestamp": "2026-01-02T03:04:06Z",
+	"severityNumber":    int32(17),
+	"severity":          int32(17),
+	"body":              "payment failed",
+	"traceId":           "5b8efff798038103d269b633813fc60c",
+	"spanId":            "eee19b7ec3c1b174",
+	"eventName":         "payment.failed",
+	"resource":          map[string]interface{}{"service.name": "billing"},
+	"scope":             map[string]interface{}{"name": "app.logger", "version": "1.2.0"},
+	"attributes": map[string]interface{}{
+		"http.status_code": json.Number("503"),
+		"retry":            true,
+		"ratio":            json.Number("0.25"),
+		"list":             []interface{}{json.Number("1"), "two"},
+		"map":              map[string]interface{}{"inner": true},
+		"raw":              "3q0=",
+	},
+}
+
+func TestDecodeOTLP(t *testing.T) {
+	decoders := map[string]func() (otlpRequest, error){
+		"protobuf": func() (otlpRequest, error) { return decodeOTLPProto(otlpTestProto()) },
+		"json":     func() (otlpRequest, error) { return decodeOTLPJSON([]byte(otlpTestJSON)) },
+	}
+	for name, decode := range decoders {
+		request, err := decode()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		lines := otlpLines(request)
+		if len(lines) != 1 {
+			t.Errorf("%s: got %d lines, want 1", name, len(lines))
+			continue
+		}
+		if !reflect.DeepEqual(lines[0], otlpTestLine) {
+			t.Errorf("%s:\ngot  %v\nwant %v", name, lines[0], otlpTestLine)
+		}
+
+		// The numeric severity maps to a level through the default -level-fields
+		data, _ := json.Marshal(lines[0])
+		if level := extractLevel(string(data), parseJSONFields(string(data))); level != "error" {
+			t.Errorf("%s: level %q, want error", name, level)
+		}
+	}
+}
+
+func TestDecodeOTLPProtoInvalid(t *testing.T) {
+	valid := otlpTestProto()
+	for name, data := range map[string][]byte{
+		"truncated":      valid[:len(valid)-3],
+		"bad tag":        {0xff},
+		"length overrun": {0x0a, 0x10, 0x01},
+	} {
+		if _, err := decodeOTLPProto(data); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestOTLPResponse(t *testing.T) {
+	if got := otlpResponse(true, 0, ""); got != nil {
+		t.Errorf("protobuf full success: got %x, want an empty body", got)
+	}
+	if got := string(otlpResponse(false, 0, "")); got != "{}" {
+		t.Errorf("json full success: got %s, want {}", got)
+	}
+
+	var response struct {
+		PartialSuccess struct {
+			RejectedLogRecords string `json:"rejectedLogRecords"`
+			ErrorMessage       string `json:"errorMessage"`
+		} `json:"partialSuccess"`
+	}
+	if err := json.Unmarshal(otlpResponse(false, 2, "max batches reached"), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.PartialSuccess.RejectedLogRecords != "2" || response.PartialSuccess.ErrorMessage != "max batches reached" {
+		t.Errorf("json partial success: got %+v", response.PartialSuccess)
+	}
+
+	var rejected uint64
+	var message string
+	err := protoFields(otlpResponse(true, 2, "max batches reached"), func(num protowire.Number, field protoField) error {
+		if num != 1 {
+			t.Errorf("unexpected response field %d", num)
+		}
+		return protoFields(field.bytes, func(num protowire.Number, field protoField) error {
+			switch num {
+			case 1:
+				rejected = field.scalar
+			case 2:
+				message = string(field.bytes)
+			}
+			return nil
+		})
+	})
+	if err != nil || rejected != 2 || message != "max batches reached" {
+		t.Errorf("protobuf partial success: rejected %d, message %q, err %v", rejected, message, err)
+	}
+}
+
+func TestProcessOTLPRejected(t *testing.T) {
+	request, err := decodeOTLPJSON([]byte(otlpTestJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.ResourceLogs = append(request.ResourceLogs, request.ResourceLogs...)
+
+	ingestor := NewLogIngestor(newMemStorage())
+	if accepted, rejected, err := ingestor.ProcessOTLP(request); accepted != 2 || rejected != 0 || err != nil {
+		t.Errorf("got %d accepted, %d rejected, %v; want 2 accepted", accepted, rejected, err)
+	}
+
+	ingestor.exhausted.Store(true)
+	accepted, rejected, err := ingestor.ProcessOTLP(request)
+	if accepted != 0 || rejected != 2 || !errors.Is(err, ErrMaxBatchesReached) {
+		t.Errorf("got %d accepted, %d rejected, %v; want 2 rejected", accepted, rejected, err)
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// runReportLayout formats run start and end times in report object keys
+const runReportLayout = "20060102T150405Z"
+
+// RunReport summarizes one ingestor run and is written to storage on shutdown
+type RunReport struct {
+	Start        time.Time                `json:"start"`
+	End          time.Time                `json:"end"`
+	TotalLines   int64                    `json:"total_lines"`
+	UniqueLines  int64                    `json:"unique_lines"`
+	Duplicates   int64                    `json:"duplicates_skipped"`
+	Partitions   int                      `json:"partitions"`
+	Batches      int                      `json:"batches"`
+	FilesWritten int                      `json:"files_written"`
+	FilesSpilled int                      `json:"files_spilled"`
+	BytesWritten int64                    `json:"bytes_written"`
+	WriteErrors  int64                    `json:"write_errors"`
+	FlushErrors  int                      `json:"flush_errors"`
+	Backends     []BackendMetricsSnapshot `json:"backends"`
+}
+
+// writeRunReport persists a RunReport as <prefix>/_runs/<start>-<end>.json
+func (li *LogIngestor) writeRunReport() error {
+	lineCount, partitionCount, duplicateCount, uniqueCount := li.GetStats()
+
+	li.mu.Lock()
+	report := RunReport{
+		Start:        li.startTime.UTC(),
+		End:          time.Now().UTC(),
+		TotalLines:   lineCount,
+		UniqueLines:  uniqueCount,
+		Duplicates:   duplicateCount,
+		Partitions:   partitionCount,
+		Batches:      li.batchesFlushed,
+		FilesWritten: li.filesWritten,
+		FilesSpilled: li.filesSpilled,
+		BytesWritten: li.bytesWritten,
+		FlushErrors:  li.flushErrors,
+		Backends:     backendMetricsSnapshot(),
+	}
+	li.mu.Unlock()
+
+	// Bytes are counted per file above; a tee's per-backend bytes stay under Backends
+	for _, backend := range report.Backends {
+		report.WriteErrors += backend.Errors
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding run report: %w", err)
+	}
+
+	key := fmt.Sprintf("%s/_runs/%s-%s.json", *prefix,
+		report.Start.Format(runReportLayout), report.End.Format(runReportLayout))
+	if err := li.storage.Put(context.TODO(), key, data); err != nil {
+		return fmt.Errorf("error writing run report: %w", err)
+	}
+
+	log.Printf("Wrote run report to %s/%s", li.storage.Name(), key)
+	return nil
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunReportBytesOncePerFile(t *testing.T) {
+	primary, secondary := newMemStorage(), newMemStorage()
+	ingestor := NewLogIngestor(NewMultiStorage([]Storage{primary, secondary}, 0))
+	if err := ingestor.ProcessLine(`{"level":"info","msg":"teed"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ingestor.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var parquetBytes int64
+	for key, data := range primary.objects {
+		if strings.HasSuffix(key, ".parquet") {
+			parquetBytes += int64(len(data))
+		}
+	}
+	if parquetBytes == 0 {
+		t.Fatal("no parquet file was written")
+	}
+
+	if err := ingestor.writeRunReport(); err != nil {
+		t.Fatal(err)
+	}
+	var report RunReport
+	for key, data := range secondary.objects {
+		if strings.Contains(key, "/_runs/") {
+			if err := json.Unmarshal(data, &report); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if report.FilesWritten != 1 || report.BytesWritten != parquetBytes {
+		t.Errorf("report: %d files, %d bytes; want 1 file, %d bytes (not doubled by the tee)",
+			report.FilesWritten, report.BytesWritten, parquetBytes)
+	}
+}
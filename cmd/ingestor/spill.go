@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// retryStorage retries failed writes to another backend with exponential backoff
+type retryStorage struct {
+	Storage
+	retries int
+	delay   time.Duration
+}
+
+// withRetries wraps a backend with -upload-retries, or returns it unchanged
+// when retries are disabled
+func withRetries(storage Storage) Storage {
+	if *uploadRetries <= 0 {
+		return storage
+	}
+	return &retryStorage{Storage: storage, retries: *uploadRetries, delay: *uploadRetryDelay}
+}
+
+func (s *retryStorage) Put(ctx context.Context, key string, data []byte) error {
+	err := s.Storage.Put(ctx, key, data)
+	delay := s.delay
+	for attempt := 1; err != nil && attempt <= s.retries; attempt++ {
+		log.Printf("Write of %s to %s failed (attempt %d/%d), retrying in %v: %v",
+			key, s.Name(), attempt, s.retries+1, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+		err = s.Storage.Put(ctx, key, data)
+	}
+	return err
+}
+
+// ErrSpilled is returned by spillStorage.Put when an object could not be
+// written to storage and was saved to -spill-dir instead. The data is safe
+// but not yet readable from storage.
+var ErrSpilled = errors.New("spilled to -spill-dir")
+
+// spillStorage writes objects that the wrapped storage rejects to a local
+// directory (-spill-dir) under their intended key, so a failed flush never
+// loses data. Spilled files can be re-uploaded with -drain-spill.
+type spillStorage struct {
+	Storage
+	dir string
+}
+
+func (s *spillStorage) Put(ctx context.Context, key string, data []byte) error {
+	err := s.Storage.Put(ctx, key, data)
+	if err == nil {
+		return nil
+	}
+
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if spillErr := writeSpillFile(path, data); spillErr != nil {
+		return fmt.Errorf("%w (spill to %s also failed: %v)", err, path, spillErr)
+	}
+	log.Printf("Spilled %s (%d bytes) to %s after write to %s failed: %v", key, len(data), path, s.Name(), err)
+	return fmt.Errorf("%w: %s (write to %s failed: %v)", ErrSpilled, path, s.Name(), err)
+}
+
+func writeSpillFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// drainSpill uploads every spilled file back through the wrapped storage and
+// removes the local copies that were written successfully
+func (s *spillStorage) drainSpill(ctx context.Context) {
+	drained, failed := 0, 0
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := s.Storage.Put(ctx, key, data); err != nil {
+			log.Printf("Error draining spilled %s: %v", key, err)
+			failed++
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		drained++
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error draining spill directory %s: %v", s.dir, err)
+	}
+	if drained > 0 || failed > 0 {
+		log.Printf("Drained %d spilled files from %s (%d still pending)", drained, s.dir, failed)
+	}
+}
+
+// unwrapSpill returns the storage beneath a spill directory, if any
+func unwrapSpill(storage Storage) Storage {
+	if spill, ok := storage.(*spillStorage); ok {
+		return spill.Storage
+	}
+	return storage
+}
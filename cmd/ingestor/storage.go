@@ -0,0 +1,440 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/parquet-go/parquet-go"
+
+	"blobsearch/internal/logstore"
+)
+
+// Storage is a backend that flushed objects are written to
+type Storage interface {
+	// Name identifies the backend in logs and stats
+	Name() string
+	// Put writes data under key
+	Put(ctx context.Context, key string, data []byte) error
+	// Get reads the object stored under key
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes the object stored under key
+	Delete(ctx context.Context, key string) error
+}
+
+// S3Storage writes objects to an S3 (or S3-compatible) bucket
+type S3Storage struct {
+	client  *s3.Client
+	bucket  string
+	options S3PutOptions
+}
+
+// S3PutOptions are the encryption and tagging settings applied to every
+// object written; the zero value leaves them to the bucket's defaults
+type S3PutOptions struct {
+	SSE      types.ServerSideEncryption
+	KMSKeyID string
+	Tagging  string // URL-encoded key=value pairs
+}
+
+// NewS3Storage creates a storage backend for the given bucket
+func NewS3Storage(client *s3.Client, bucket string, options S3PutOptions) *S3Storage {
+	return &S3Storage{client: client, bucket: bucket, options: options}
+}
+
+// parseS3PutOptions validates -s3-sse, -s3-kms-key-id and -s3-tags
+func parseS3PutOptions() (S3PutOptions, error) {
+	var options S3PutOptions
+	switch strings.ToLower(*s3SSE) {
+	case "":
+	case "aes256":
+		options.SSE = types.ServerSideEncryptionAes256
+	case "aws:kms":
+		options.SSE = types.ServerSideEncryptionAwsKms
+	default:
+		return options, fmt.Errorf("invalid -s3-sse %q (expected aes256 or aws:kms)", *s3SSE)
+	}
+	if *s3KMSKeyID != "" {
+		if options.SSE != types.ServerSideEncryptionAwsKms {
+			return options, fmt.Errorf("-s3-kms-key-id requires -s3-sse aws:kms")
+		}
+		options.KMSKeyID = *s3KMSKeyID
+	}
+
+	tags := url.Values{}
+	for _, pair := range strings.Split(*s3Tags, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return options, fmt.Errorf("invalid -s3-tags entry %q (expected key=value)", pair)
+		}
+		tags.Set(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+	options.Tagging = tags.Encode()
+	return options, nil
+}
+
+// objectMetadataKey carries user metadata for the object being written
+type objectMetadataKey struct{}
+
+// withObjectMetadata attaches user metadata for backends that support it
+func withObjectMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	return context.WithValue(ctx, objectMetadataKey{}, metadata)
+}
+
+// contentType picks an object's Content-Type from its key
+func contentType(key string) string {
+	switch path.Ext(key) {
+	case ".parquet":
+		return "application/vnd.apache.parquet"
+	case ".json":
+		return "application/json"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func (s *S3Storage) Name() string {
+	return "s3://" + s.bucket
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte) error {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType(key)),
+	}
+	if metadata, ok := ctx.Value(objectMetadataKey{}).(map[string]string); ok {
+		input.Metadata = metadata
+	}
+	if s.options.SSE != "" {
+		input.ServerSideEncryption = s.options.SSE
+	}
+	if s.options.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.options.KMSKeyID)
+	}
+	if s.options.Tagging != "" {
+		input.Tagging = aws.String(s.options.Tagging)
+	}
+
+	_, err := s.client.PutObject(ctx, input)
+	if err != nil {
+		return fmt.Errorf("error uploading to S3: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error downloading from S3: %w", err)
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting from S3: %w", err)
+	}
+	return nil
+}
+
+// LocalStorage writes objects as files below a directory
+type LocalStorage struct {
+	dir string
+}
+
+// NewLocalStorage creates a storage backend rooted at dir, creating it if needed
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating output directory: %w", err)
+	}
+	return &LocalStorage{dir: dir}, nil
+}
+
+func (l *LocalStorage) Name() string {
+	return l.dir
+}
+
+func (l *LocalStorage) Put(ctx context.Context, key string, data []byte) error {
+	localPath := filepath.Join(l.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing local file: %w", err)
+	}
+	return nil
+}
+
+func (l *LocalStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, filepath.FromSlash(key)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading local file: %w", err)
+	}
+	return data, nil
+}
+
+func (l *LocalStorage) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(filepath.Join(l.dir, filepath.FromSlash(key))); err != nil {
+		return fmt.Errorf("error removing local file: %w", err)
+	}
+	return nil
+}
+
+// MultiStorage tees every write to several backends
+type MultiStorage struct {
+	backends []Storage
+	quorum   int
+	partial  atomic.Int64 // writes that met quorum but missed a backend
+}
+
+// NewMultiStorage creates a tee over backends; a write succeeds once quorum
+// backends have accepted it (quorum <= 0 requires all of them)
+func NewMultiStorage(backends []Storage, quorum int) *MultiStorage {
+	if quorum <= 0 || quorum > len(backends) {
+		quorum = len(backends)
+	}
+	return &MultiStorage{backends: backends, quorum: quorum}
+}
+
+func (m *MultiStorage) Name() string {
+	names := make([]string, len(m.backends))
+	for i, backend := range m.backends {
+		names[i] = backend.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (m *MultiStorage) Put(ctx context.Context, key string, data []byte) error {
+	succeeded := 0
+	var errs []string
+	for _, backend := range m.backends {
+		if err := backend.Put(ctx, key, data); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", backend.Name(), err))
+			continue
+		}
+		succeeded++
+	}
+
+	if succeeded < m.quorum {
+		return fmt.Errorf("write reached %d/%d backends (quorum %d): %s",
+			succeeded, len(m.backends), m.quorum, strings.Join(errs, "; "))
+	}
+	if len(errs) > 0 {
+		// The flush succeeds, but the missing copies must not go unnoticed
+		m.partial.Add(1)
+		for _, err := range errs {
+			log.Printf("Write of %s reached %d/%d backends (quorum %d), missing %s",
+				key, succeeded, len(m.backends), m.quorum, err)
+		}
+	}
+	return nil
+}
+
+// PartialWrites returns how many writes met quorum but failed on a backend
+func (m *MultiStorage) PartialWrites() int64 {
+	return m.partial.Load()
+}
+
+// multiStorageOf returns the tee under the spill and breaker wrappers, if any
+func multiStorageOf(storage Storage) (*MultiStorage, bool) {
+	storage = unwrapSpill(storage)
+	if guarded, ok := storage.(*breakerStorage); ok {
+		storage = guarded.Storage
+	}
+	multi, ok := storage.(*MultiStorage)
+	return multi, ok
+}
+
+// Get reads from the first backend that has the object
+func (m *MultiStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	var lastErr error
+	for _, backend := range m.backends {
+		data, err := backend.Get(ctx, key)
+		if err == nil {
+			return data, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Delete removes the object from every backend
+func (m *MultiStorage) Delete(ctx context.Context, key string) error {
+	var errs []string
+	for _, backend := range m.backends {
+		if err := backend.Delete(ctx, key); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", backend.Name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("delete failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// newS3Client creates an S3 client from the endpoint and credential flags
+func newS3Client() (*s3.Client, error) {
+	return logstore.NewS3Client(context.TODO(), logstore.S3Config{
+		Endpoint:  *endpoint,
+		Region:    *region,
+		AccessKey: *accessKey,
+		SecretKey: *secretKey,
+	})
+}
+
+// backendNames returns the backends selected by -backend (or -local)
+func backendNames() []string {
+	if *backend == "" {
+		if *localFile {
+			return []string{"local"}
+		}
+		return []string{"s3"}
+	}
+	var names []string
+	for _, name := range strings.Split(*backend, ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	return names
+}
+
+// newStorage builds the storage backend(s) selected by -backend (or -local)
+func newStorage() (Storage, error) {
+	names := backendNames()
+	if *s3SSE != "" || *s3KMSKeyID != "" || *s3Tags != "" {
+		// Encryption and tags have no local equivalent
+		if !slices.Contains(names, "s3") {
+			return nil, fmt.Errorf("-s3-sse, -s3-kms-key-id and -s3-tags need an s3 backend (have %s)", strings.Join(names, ","))
+		}
+		if slices.Contains(names, "local") {
+			log.Printf("-s3-sse, -s3-kms-key-id and -s3-tags apply only to the s3 backend; local copies are written without them")
+		}
+	}
+
+	var backends []Storage
+	for _, name := range names {
+		switch name {
+		case "s3":
+			options, err := parseS3PutOptions()
+			if err != nil {
+				return nil, err
+			}
+			client, err := newS3Client()
+			if err != nil {
+				return nil, err
+			}
+			backends = append(backends, withRetries(newInstrumentedStorage(NewS3Storage(client, *bucket, options), "s3", *bucket)))
+		case "local":
+			dir := *localDir
+			if dir == "" {
+				dir = *bucket
+			}
+			local, err := NewLocalStorage(dir)
+			if err != nil {
+				return nil, err
+			}
+			backends = append(backends, withRetries(newInstrumentedStorage(local, "local", dir)))
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown backend %q (expected s3 or local)", name)
+		}
+	}
+
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no storage backend configured")
+	}
+	var storage Storage = backends[0]
+	if len(backends) > 1 {
+		storage = NewMultiStorage(backends, *backendQuorum)
+	}
+
+	if *breakerThreshold > 0 {
+		storage = &breakerStorage{
+			Storage: storage,
+			breaker: NewCircuitBreaker(*breakerThreshold, *breakerCooldown),
+		}
+	}
+
+	if *spillDir != "" {
+		storage = &spillStorage{Storage: storage, dir: *spillDir}
+	}
+	return storage, nil
+}
+
+// runSelfTest writes a probe parquet file to every backend, reads it back and
+// removes it, so misconfigured storage fails at startup instead of at the first flush
+func runSelfTest(storage Storage) error {
+	storage = unwrapSpill(storage)
+	if guarded, ok := storage.(*breakerStorage); ok {
+		storage = guarded.Storage
+	}
+	backends := []Storage{storage}
+	if multi, ok := storage.(*MultiStorage); ok {
+		backends = multi.backends
+	}
+
+	probe := LogEntry{
+		Timestamp: time.Now(),
+		Message:   "blobsearch self-test probe",
+		Level:     "info",
+	}
+	data, err := encodeParquet([]LogEntry{probe})
+	if err != nil {
+		return fmt.Errorf("encoding probe: %w", err)
+	}
+
+	key := fmt.Sprintf("%s/_selftest/probe-%d.parquet", *prefix, probe.Timestamp.UnixNano())
+	ctx := context.TODO()
+	for _, backend := range backends {
+		if err := backend.Put(ctx, key, data); err != nil {
+			return fmt.Errorf("%s: writing probe: %w", backend.Name(), err)
+		}
+
+		readBack, err := backend.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("%s: reading probe: %w", backend.Name(), err)
+		}
+
+		reader := parquet.NewGenericReader[LogEntry](bytes.NewReader(readBack))
+		rows := make([]LogEntry, 1)
+		n, _ := reader.Read(rows)
+		reader.Close()
+		if n != 1 || rows[0].Message != probe.Message {
+			return fmt.Errorf("%s: probe read back does not match what was written", backend.Name())
+		}
+
+		if err := backend.Delete(ctx, key); err != nil {
+			return fmt.Errorf("%s: removing probe: %w", backend.Name(), err)
+		}
+		log.Printf("Self-test passed for %s", backend.Name())
+	}
+	return nil
+}
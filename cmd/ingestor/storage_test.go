@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// failingStorage rejects every write
+type failingStorage struct{ *memStorage }
+
+func (f failingStorage) Name() string { return "broken" }
+
+func (f failingStorage) Put(ctx context.Context, key string, data []byte) error {
+	return errors.New("disk full")
+}
+
+func TestMultiStoragePartialWrite(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	multi := NewMultiStorage([]Storage{newMemStorage(), failingStorage{newMemStorage()}}, 1)
+	if err := multi.Put(context.Background(), "a.parquet", []byte("data")); err != nil {
+		t.Fatalf("quorum 1 of 2 should succeed: %v", err)
+	}
+	if got := multi.PartialWrites(); got != 1 {
+		t.Errorf("partial writes = %d, want 1", got)
+	}
+	if !strings.Contains(logged.String(), "a.parquet reached 1/2 backends (quorum 1), missing broken: disk full") {
+		t.Errorf("the missed backend should be logged, got %q", logged.String())
+	}
+
+	// A write that fails quorum is an error, not a partial write
+	strict := NewMultiStorage([]Storage{newMemStorage(), failingStorage{newMemStorage()}}, 0)
+	if err := strict.Put(context.Background(), "b.parquet", []byte("data")); err == nil {
+		t.Error("quorum 2 of 2 should fail")
+	}
+	if got := strict.PartialWrites(); got != 0 {
+		t.Errorf("partial writes = %d, want 0", got)
+	}
+
+	// The counter is found under the breaker and spill wrappers
+	wrapped := &spillStorage{Storage: &breakerStorage{Storage: multi}}
+	if found, ok := multiStorageOf(wrapped); !ok || found != multi {
+		t.Error("multiStorageOf should unwrap the spill and breaker wrappers")
+	}
+}
@@ -0,0 +1,332 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslogMaxMessage bounds a single TCP syslog frame
+const syslogMaxMessage = 1 << 20
+
+// syslogLevel maps a syslog severity (0-7) to a level bucket
+func syslogLevel(severity int) string {
+	switch {
+	case severity <= 3: // Emergency, Alert, Critical, Error
+		return "error"
+	case severity == 4: // Warning
+		return "warn"
+	case severity <= 6: // Notice, Informational
+		return "info"
+	default: // Debug
+		return "debug"
+	}
+}
+
+// parseSyslog parses an RFC5424 or RFC3164 (BSD) message into the fields of
+// the JSON line it is ingested as
+func parseSyslog(line string, now time.Time) (map[string]interface{}, error) {
+	line = strings.TrimRight(line, "\r\n\x00")
+	if !strings.HasPrefix(line, "<") {
+		return nil, errors.New("missing <PRI>")
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return nil, errors.New("malformed <PRI>")
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri > 191 {
+		return nil, fmt.Errorf("invalid PRI %q", line[1:end])
+	}
+	rest := line[end+1:]
+
+	fields := map[string]interface{}{
+		"facility": pri / 8,
+		"severity": pri % 8,
+		"level":    syslogLevel(pri % 8),
+	}
+
+	if strings.HasPrefix(rest, "1 ") {
+		err = parseRFC5424(rest[2:], fields)
+	} else {
+		parseRFC3164(rest, now, fields)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := fields["timestamp"]; !ok {
+		fields["timestamp"] = now.Format(time.RFC3339Nano)
+	}
+	return fields, nil
+}
+
+// parseRFC5424 parses "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD [MSG]"
+func parseRFC5424(rest string, fields map[string]interface{}) error {
+	header := make([]string, 5)
+	for i := range header {
+		token, remainder, ok := strings.Cut(rest, " ")
+		if !ok && i < 4 {
+			return errors.New("truncated RFC5424 header")
+		}
+		header[i], rest = token, remainder
+	}
+
+	if header[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, header[0])
+		if err != nil {
+			return fmt.Errorf("invalid RFC5424 timestamp %q", header[0])
+		}
+		fields["timestamp"] = t.Format(time.RFC3339Nano)
+	}
+	for i, name := range []string{"", "host", "app", "procid", "msgid"} {
+		if i > 0 && header[i] != "-" && header[i] != "" {
+			fields[name] = header[i]
+		}
+	}
+
+	sd, msg, err := parseStructuredData(rest)
+	if err != nil {
+		return err
+	}
+	if len(sd) > 0 {
+		fields["structured_data"] = sd
+	}
+	fields["message"] = strings.TrimPrefix(msg, "\ufeff") // optional UTF-8 BOM
+	return nil
+}
+
+// parseStructuredData parses RFC5424 SD-ELEMENTs ([id name="value" ...]...)
+// and returns them keyed by SD-ID, along with the remaining message
+func parseStructuredData(s string) (map[string]interface{}, string, error) {
+	if strings.HasPrefix(s, "-") {
+		return nil, strings.TrimPrefix(strings.TrimPrefix(s, "-"), " "), nil
+	}
+
+	elements := make(map[string]interface{})
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		idEnd := strings.IndexAny(s, " ]")
+		if idEnd < 0 {
+			return nil, "", errors.New("unterminated structured data")
+		}
+		params := make(map[string]interface{})
+		elements[s[:idEnd]] = params
+		s = s[idEnd:]
+
+		for {
+			s = strings.TrimLeft(s, " ")
+			if strings.HasPrefix(s, "]") {
+				s = s[1:]
+				break
+			}
+			name, value, ok := strings.Cut(s, "=\"")
+			if !ok {
+				return nil, "", errors.New("malformed structured data parameter")
+			}
+
+			// PARAM-VALUE escapes '"', '\' and ']' with a backslash
+			var b strings.Builder
+			i := 0
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) && strings.IndexByte(`"\]`, value[i+1]) >= 0 {
+					i++
+				}
+				b.WriteByte(value[i])
+			}
+			if i == len(value) {
+				return nil, "", errors.New("unterminated structured data value")
+			}
+			params[name] = b.String()
+			s = value[i+1:]
+		}
+	}
+	return elements, strings.TrimPrefix(s, " "), nil
+}
+
+// parseRFC3164 parses "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG". BSD syslog is
+// loosely specified, so anything that doesn't fit is kept as the message.
+func parseRFC3164(rest string, now time.Time, fields map[string]interface{}) {
+	const layout = "Jan _2 15:04:05"
+	if len(rest) >= len(layout) {
+		if t, err := time.ParseInLocation(layout, rest[:len(layout)], now.Location()); err == nil {
+			// The year is implied; a date ahead of now belongs to last year
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			fields["timestamp"] = t.Format(time.RFC3339Nano)
+			rest = strings.TrimPrefix(rest[len(layout):], " ")
+
+			if host, remainder, ok := strings.Cut(rest, " "); ok {
+				fields["host"] = host
+				rest = remainder
+			}
+		}
+	}
+
+	// TAG is alphanumeric, optionally followed by [PID], and ends with ':'
+	if colon := strings.Index(rest, ": "); colon > 0 && !strings.ContainsAny(rest[:colon], " ") {
+		tag := rest[:colon]
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			fields["procid"] = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+		fields["app"] = tag
+		rest = rest[colon+2:]
+	}
+	fields["message"] = rest
+}
+
+// processSyslog ingests one syslog message as a normalized JSON line
+func processSyslog(ingestor *LogIngestor, raw string) error {
+	fields, err := parseSyslog(raw, time.Now())
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("failed to marshal syslog to JSON: %v", err)
+	}
+	return ingestor.ProcessLine(string(jsonBytes))
+}
+
+// StartSyslogTCPServer receives syslog over TCP, accepting both octet-counted
+// and newline-delimited framing (RFC 6587). It stops when ctx is cancelled;
+// open connections are tracked in conns.
+func StartSyslogTCPServer(ctx context.Context, addr string, ingestor *LogIngestor, conns *connGroup) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on TCP: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	log.Printf("Syslog TCP server listening on %s", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("Error accepting syslog connection: %v", err)
+			continue
+		}
+		conns.Serve(conn, func(conn net.Conn) {
+			handleSyslogConnection(conn, ingestor)
+		})
+	}
+}
+
+func handleSyslogConnection(conn net.Conn, ingestor *LogIngestor) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for {
+		message, err := readSyslogFrame(reader)
+		if message != "" {
+			if err := processSyslog(ingestor, message); err != nil {
+				log.Printf("Error processing syslog from %s: %v", conn.RemoteAddr(), err)
+			}
+		}
+		if err != nil {
+			// Shutdown closes open connections; that is not a read error
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Error reading syslog from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// readSyslogFrame reads one "LEN SP MSG" octet-counted frame, or one line.
+// Neither is buffered past syslogMaxMessage, so a sender can't exhaust memory.
+func readSyslogFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		// ReadSlice fails rather than grow past the reader's buffer
+		lengthBytes, err := reader.ReadSlice(' ')
+		if err == bufio.ErrBufferFull {
+			return "", errors.New("octet count not followed by a space")
+		} else if err != nil {
+			return "", err
+		}
+		lengthStr := string(lengthBytes)
+		length, err := strconv.Atoi(strings.TrimSuffix(lengthStr, " "))
+		if err != nil || length > syslogMaxMessage {
+			return "", fmt.Errorf("invalid octet count %q", lengthStr)
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			return "", err
+		}
+		return string(frame), nil
+	}
+
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > syslogMaxMessage {
+			return "", fmt.Errorf("message exceeds %d bytes", syslogMaxMessage)
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return strings.TrimSpace(string(line)), err
+		}
+	}
+}
+
+// StartSyslogUDPServer receives one syslog message per datagram. It stops
+// reading when ctx is cancelled.
+func StartSyslogUDPServer(ctx context.Context, addr string, ingestor *LogIngestor) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to resolve UDP address: %v", err)
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on UDP: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	log.Printf("Syslog UDP server listening on %s", conn.LocalAddr())
+
+	buffer := make([]byte, 65536)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("Error reading syslog from UDP: %v", err)
+			continue
+		}
+
+		if err := processSyslog(ingestor, string(buffer[:n])); err != nil {
+			log.Printf("Error processing syslog from %s: %v", remoteAddr, err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	nowStamp := now.Format(time.RFC3339Nano)
+
+	tests := []struct {
+		name string
+		line string
+		now  time.Time
+		want map[string]interface{}
+	}{
+		{
+			name: "RFC5424 with structured data",
+			line: `<165>1 2026-01-02T03:04:05.123Z web-1 billing 4242 ID47 [origin ip="10.0.0.1" note="a \"quoted\] value"][meta seq="7"] ` + "\ufeff" + "disk full\r\n",
+			want: map[string]interface{}{
+				"facility": 20, "severity": 5, "level": "info",
+				"timestamp": "2026-01-02T03:04:05.123Z",
+				"host":      "web-1", "app": "billing", "procid": "4242", "msgid": "ID47",
+				"structured_data": map[string]interface{}{
+					"origin": map[string]interface{}{"ip": "10.0.0.1", "note": `a "quoted] value`},
+					"meta":   map[string]interface{}{"seq": "7"},
+				},
+				"message": "disk full",
+			},
+		},
+		{
+			name: "RFC5424 with nil values",
+			line: "<11>1 - - - - - -",
+			want: map[string]interface{}{
+				"facility": 1, "severity": 3, "level": "error",
+				"timestamp": nowStamp, "message": "",
+			},
+		},
+		{
+			name: "RFC3164",
+			line: "<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick",
+			want: map[string]interface{}{
+				"facility": 4, "severity": 2, "level": "error",
+				"timestamp": "2026-10-11T22:14:15Z",
+				"host":      "mymachine", "app": "su", "procid": "123",
+				"message": "'su root' failed for lonvick",
+			},
+		},
+		{
+			name: "RFC3164 from last year",
+			line: "<15>Dec 31 23:59:59 db-2 cron: nightly job",
+			now:  time.Date(2026, 1, 1, 0, 0, 5, 0, time.UTC),
+			want: map[string]interface{}{
+				"facility": 1, "severity": 7, "level": "debug",
+				"timestamp": "2025-12-31T23:59:59Z",
+				"host":      "db-2", "app": "cron", "message": "nightly job",
+			},
+		},
+		{
+			name: "RFC3164 without a header",
+			line: "<12>something odd happened: again",
+			want: map[string]interface{}{
+				"facility": 1, "severity": 4, "level": "warn",
+				"timestamp": nowStamp, "message": "something odd happened: again",
+			},
+		},
+	}
+	for _, tt := range tests {
+		if tt.now.IsZero() {
+			tt.now = now
+		}
+		got, err := parseSyslog(tt.line, tt.now)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %v\nwant %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseSyslogInvalid(t *testing.T) {
+	for _, line := range []string{
+		"no priority",
+		"<>1 - - - - - -",
+		"<192>1 - - - - - -",
+		"<1x>message",
+		"<14>1 2026-01-02T03:04:05Z host",     // truncated header
+		"<14>1 yesterday host app - - - hi",   // bad timestamp
+		"<14>1 - host app - - [unterminated",  // bad structured data
+		`<14>1 - host app - - [id key="open]`, // unterminated value
+	} {
+		if fields, err := parseSyslog(line, time.Now()); err == nil {
+			t.Errorf("parseSyslog(%q) = %v, want an error", line, fields)
+		}
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	input := "11 <14>1 - - -" + // octet counted, no trailing newline
+		"<13>plain line\n" +
+		"8 <13>a\nb\n" + // octet counting keeps embedded newlines
+		"<13>last line without newline"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	want := []string{"<14>1 - - -", "<13>plain line", "<13>a\nb\n", "<13>last line without newline"}
+	for _, frame := range want {
+		got, err := readSyslogFrame(reader)
+		if got != frame || (err != nil && err != io.EOF) {
+			t.Fatalf("got %q, %v; want %q", got, err, frame)
+		}
+	}
+	if _, err := readSyslogFrame(reader); err != io.EOF {
+		t.Errorf("after the last frame: got %v, want io.EOF", err)
+	}
+}
+
+func TestReadSyslogFrameInvalid(t *testing.T) {
+	for name, input := range map[string]string{
+		"oversized count":    "2000000 <14>hi",
+		"count not a number": "12x <14>hi",
+		"short frame":        "20 <14>hi",
+	} {
+		if frame, err := readSyslogFrame(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("%s: got %q, %v; want an error", name, frame, err)
+		}
+	}
+}
+
+// endlessReader yields the same byte forever
+type endlessReader byte
+
+func (r endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+func TestReadSyslogFrameOversized(t *testing.T) {
+	// Neither an unterminated line nor an endless octet count may be read
+	// without bound
+	for name, reader := range map[string]io.Reader{
+		"endless line":  io.MultiReader(strings.NewReader("<13>"), endlessReader('a')),
+		"endless count": endlessReader('7'),
+		"long line":     strings.NewReader("<13>" + strings.Repeat("a", syslogMaxMessage) + "\n"),
+	} {
+		if frame, err := readSyslogFrame(bufio.NewReader(reader)); err == nil {
+			t.Errorf("%s: read a %d byte frame, want an error", name, len(frame))
+		}
+	}
+
+	// A line of exactly the limit is still accepted
+	line := "<13>" + strings.Repeat("a", syslogMaxMessage-5) + "\n"
+	if frame, err := readSyslogFrame(bufio.NewReader(strings.NewReader(line))); err != nil || len(frame) != syslogMaxMessage-1 {
+		t.Errorf("line at the limit: got %d bytes, %v", len(frame), err)
+	}
+}
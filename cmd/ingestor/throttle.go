@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReplayThrottle paces backfill ingestion with a token bucket. Each line costs
+// one token in lines mode, or its length in bytes mode.
+type ReplayThrottle struct {
+	rate   float64 // tokens per second
+	burst  float64
+	bytes  bool
+	tokens float64
+	last   time.Time
+}
+
+// parseReplayRate parses -replay-rate values such as "500" (lines/sec),
+// "500l" (lines/sec) or "2mb" (bytes/sec, with optional kb/mb/gb suffix)
+func parseReplayRate(spec string) (*ReplayThrottle, error) {
+	s := strings.ToLower(strings.TrimSpace(spec))
+	if s == "" || s == "0" {
+		return nil, nil
+	}
+	s = strings.TrimSuffix(s, "/s")
+
+	multiplier := 1.0
+	bytesMode := false
+	for _, unit := range []struct {
+		suffix string
+		mult   float64
+		bytes  bool
+	}{
+		{"gb", 1 << 30, true},
+		{"mb", 1 << 20, true},
+		{"kb", 1 << 10, true},
+		{"b", 1, true},
+		{"l", 1, false},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSuffix(s, unit.suffix)
+			multiplier = unit.mult
+			bytesMode = unit.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid replay rate %q (expected e.g. 500, 500l or 2mb)", spec)
+	}
+
+	rate := n * multiplier
+	// Allow up to one second of burst so small lines aren't paced one by one
+	return &ReplayThrottle{rate: rate, burst: rate, bytes: bytesMode, tokens: rate, last: time.Now()}, nil
+}
+
+// Wait blocks until the line can be ingested within the configured rate
+func (t *ReplayThrottle) Wait(line string) {
+	cost := 1.0
+	if t.bytes {
+		cost = float64(len(line) + 1)
+	}
+
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+
+	t.tokens -= cost
+	if t.tokens < 0 {
+		time.Sleep(time.Duration(-t.tokens / t.rate * float64(time.Second)))
+	}
+}
+
+// String describes the rate for logging
+func (t *ReplayThrottle) String() string {
+	if t.bytes {
+		return fmt.Sprintf("%.0f bytes/sec", t.rate)
+	}
+	return fmt.Sprintf("%.0f lines/sec", t.rate)
+}
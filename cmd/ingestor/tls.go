@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// serverTLS is the HTTP server's TLS configuration, or nil for plaintext
+var serverTLS *tls.Config
+
+// loadServerTLS builds the TLS configuration from -tls-cert, -tls-key and
+// -client-ca. It returns nil when TLS is not configured.
+func loadServerTLS() (*tls.Config, error) {
+	if *tlsCert == "" && *tlsKey == "" {
+		if *clientCA != "" || *gelfTLS {
+			return nil, errors.New("-client-ca and -gelf-tls require -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	if *tlsCert == "" || *tlsKey == "" {
+		return nil, errors.New("-tls-cert and -tls-key must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if *clientCA != "" {
+		pem, err := os.ReadFile(*clientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", *clientCA)
+		}
+		// Certificates are verified when presented; requireClientCert enforces
+		// them on the ingest endpoints so health probes still work without one
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// gelfTLSConfig returns the TLS configuration for the GELF TCP listener, which
+// always requires a client certificate when -client-ca is set
+func gelfTLSConfig() *tls.Config {
+	if !*gelfTLS {
+		return nil
+	}
+	config := serverTLS.Clone()
+	if config.ClientCAs != nil {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config
+}
+
+// requireClientCert rejects requests without a verified client certificate
+// when mutual TLS is enabled with -client-ca
+func requireClientCert(handler http.HandlerFunc) http.HandlerFunc {
+	if serverTLS == nil || serverTLS.ClientCAs == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
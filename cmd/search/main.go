@@ -0,0 +1,418 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Command search scans the parquet files written by the ingestor, pruning
+// Hive partitions by time range and level, and prints matching entries as JSON.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/parquet-go/parquet-go"
+
+	"blobsearch/internal/logstore"
+)
+
+var (
+	bucket    = flag.String("bucket", "", "S3 bucket name or local directory")
+	prefix    = flag.String("prefix", "logs", "S3 prefix the ingestor wrote to")
+	local     = flag.Bool("local", false, "Read from a local directory (-bucket) instead of S3")
+	endpoint  = flag.String("endpoint", "", "Custom S3 endpoint (for MinIO/local S3)")
+	accessKey = flag.String("access-key", "", "AWS access key (for custom endpoint)")
+	secretKey = flag.String("secret-key", "", "AWS secret key (for custom endpoint)")
+	region    = flag.String("region", "us-east-1", "AWS region")
+	from      = flag.String("from", "", "Earliest timestamp to match (RFC3339 or YYYY-MM-DD)")
+	to        = flag.String("to", "", "Latest timestamp to match (RFC3339, or YYYY-MM-DD for the whole day)")
+	level     = flag.String("level", "", "Only match entries with this level")
+	match     = flag.String("match", "", "Only match messages containing this substring")
+	useRegex  = flag.Bool("regex", false, "Treat -match as a regular expression")
+	hasHash   = flag.String("has-hash", "", "Only match entries with this content_hash, skipping files whose bloom filter rules it out")
+	limit     = flag.Int("limit", 100, "Stop after this many matches (0 = unlimited)")
+	maxFiles  = flag.Int("query-max-files", 10000, "Refuse queries whose pruned file set exceeds this many files (0 = unlimited)")
+)
+
+type LogEntry = logstore.LogEntry
+
+// source lists and reads the objects under a prefix
+type source interface {
+	List(ctx context.Context, prefix string) ([]string, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+	Open(ctx context.Context, key string) (object, error)
+}
+
+// object is a stored file opened for random access, so a parquet footer can
+// be read without fetching the whole file
+type object interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+type localSource struct {
+	dir string
+}
+
+func (l *localSource) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	root := filepath.Join(l.dir, filepath.FromSlash(prefix))
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	return keys, err
+}
+
+func (l *localSource) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(l.dir, filepath.FromSlash(key)))
+}
+
+// localObject adds Size to an open file
+type localObject struct {
+	*os.File
+	size int64
+}
+
+func (o *localObject) Size() int64 { return o.size }
+
+func (l *localSource) Open(ctx context.Context, key string) (object, error) {
+	f, err := os.Open(filepath.Join(l.dir, filepath.FromSlash(key)))
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &localObject{File: f, size: info.Size()}, nil
+}
+
+type s3Source struct {
+	client *s3.Client
+	bucket string
+}
+
+func (s *s3Source) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix + "/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing s3://%s/%s: %w", s.bucket, prefix, err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	return keys, nil
+}
+
+func (s *s3Source) Get(ctx context.Context, key string) ([]byte, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading s3://%s/%s: %w", s.bucket, key, err)
+	}
+	defer result.Body.Close()
+	return io.ReadAll(result.Body)
+}
+
+func (s *s3Source) Open(ctx context.Context, key string) (object, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return &s3Object{ctx: ctx, source: s, key: key, size: aws.ToInt64(head.ContentLength)}, nil
+}
+
+// s3Object reads byte ranges of an S3 object with ranged GetObject requests
+type s3Object struct {
+	ctx    context.Context
+	source *s3Source
+	key    string
+	size   int64
+}
+
+func (o *s3Object) Size() int64 { return o.size }
+
+func (o *s3Object) Close() error { return nil }
+
+func (o *s3Object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if off+n > o.size {
+		n = o.size - off
+	}
+
+	result, err := o.source.client.GetObject(o.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(o.source.bucket),
+		Key:    aws.String(o.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, off+n-1)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error reading s3://%s/%s: %w", o.source.bucket, o.key, err)
+	}
+	defer result.Body.Close()
+
+	read, err := io.ReadFull(result.Body, p[:n])
+	if err == nil && n < int64(len(p)) {
+		err = io.EOF
+	}
+	return read, err
+}
+
+// timeRange is the inclusive -from/-to window; zero ends are open
+type timeRange struct {
+	from, to time.Time
+}
+
+func (r timeRange) contains(t time.Time) bool {
+	return (r.from.IsZero() || !t.Before(r.from)) && (r.to.IsZero() || !t.After(r.to))
+}
+
+// overlaps reports whether [start, end) intersects the range
+func (r timeRange) overlaps(start, end time.Time) bool {
+	return (r.from.IsZero() || end.After(r.from)) && (r.to.IsZero() || !start.After(r.to))
+}
+
+// parseTimeFlag parses RFC3339 or a bare local date, matching the local-time
+// date= partitions; a bare -to date covers the whole day
+func parseTimeFlag(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (expected RFC3339 or YYYY-MM-DD)", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// mayContainHash consults the content_hash bloom filters in a file's footer,
+// reading only the footer and filter pages. Files written without
+// -bloom-filter always may contain the hash.
+func mayContainHash(obj object, hash string) bool {
+	file, err := parquet.OpenFile(obj, obj.Size(),
+		parquet.SkipMagicBytes(true),
+		parquet.SkipPageIndex(true),
+		parquet.OptimisticRead(true),
+	)
+	if err != nil {
+		return true
+	}
+	column, ok := file.Schema().Lookup("content_hash")
+	if !ok {
+		return true
+	}
+
+	value := parquet.ValueOf(hash)
+	for _, rowGroup := range file.RowGroups() {
+		filter := rowGroup.ColumnChunks()[column.ColumnIndex].BloomFilter()
+		if filter == nil {
+			return true
+		}
+		if found, err := filter.Check(value); err != nil || found {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionMatches prunes a key by its date=, hour= and level= path segments
+func partitionMatches(key string, window timeRange) bool {
+	var date, hour, keyLevel string
+	for _, segment := range strings.Split(key, "/") {
+		name, value, ok := strings.Cut(segment, "=")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "date":
+			date = value
+		case "hour":
+			hour = value
+		case "level":
+			keyLevel = value
+		}
+	}
+
+	if *level != "" && keyLevel != "" && keyLevel != *level {
+		return false
+	}
+
+	if date == "" {
+		return true
+	}
+	// The ingestor writes date= and hour= in local time
+	day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return true
+	}
+	if h, err := strconv.Atoi(hour); err == nil {
+		start := time.Date(day.Year(), day.Month(), day.Day(), h, 0, 0, 0, time.Local)
+		return window.overlaps(start, start.Add(time.Hour))
+	}
+	return window.overlaps(day, day.AddDate(0, 0, 1))
+}
+
+func main() {
+	flag.Parse()
+
+	if *bucket == "" {
+		fmt.Fprintln(os.Stderr, "Error: bucket name is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var window timeRange
+	var err error
+	if window.from, err = parseTimeFlag(*from, false); err != nil {
+		log.Fatalf("Invalid -from: %v", err)
+	}
+	if window.to, err = parseTimeFlag(*to, true); err != nil {
+		log.Fatalf("Invalid -to: %v", err)
+	}
+	*level = strings.ToLower(*level)
+
+	matches := func(message string) bool { return strings.Contains(message, *match) }
+	if *useRegex {
+		pattern, err := regexp.Compile(*match)
+		if err != nil {
+			log.Fatalf("Invalid -match regex: %v", err)
+		}
+		matches = pattern.MatchString
+	}
+
+	ctx := context.Background()
+	var src source
+	if *local {
+		src = &localSource{dir: *bucket}
+	} else {
+		client, err := logstore.NewS3Client(ctx, logstore.S3Config{
+			Endpoint:  *endpoint,
+			Region:    *region,
+			AccessKey: *accessKey,
+			SecretKey: *secretKey,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create S3 client: %v", err)
+		}
+		src = &s3Source{client: client, bucket: *bucket}
+	}
+
+	keys, err := src.List(ctx, strings.TrimSuffix(*prefix, "/"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("Error listing files: %v", err)
+	}
+	sort.Strings(keys)
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+
+	// Skip run reports, manifests and self-test probes
+	var files []string
+	for _, key := range keys {
+		if strings.HasSuffix(key, ".parquet") && !strings.Contains(key, "/_") && partitionMatches(key, window) {
+			files = append(files, key)
+		}
+	}
+	if *maxFiles > 0 && len(files) > *maxFiles {
+		log.Fatalf("Query would scan %d files, over -query-max-files %d; narrow the time range with -from/-to or filter by -level",
+			len(files), *maxFiles)
+	}
+
+	hits, scanned, skipped := 0, 0, 0
+	for _, key := range files {
+		// Check the bloom filter before downloading the file
+		if *hasHash != "" {
+			obj, err := src.Open(ctx, key)
+			if err != nil {
+				log.Printf("Skipping %s: %v", key, err)
+				continue
+			}
+			mayContain := mayContainHash(obj, *hasHash)
+			obj.Close()
+			if !mayContain {
+				skipped++
+				continue
+			}
+		}
+
+		data, err := src.Get(ctx, key)
+		if err != nil {
+			log.Printf("Skipping %s: %v", key, err)
+			continue
+		}
+		scanned++
+
+		reader := parquet.NewGenericReader[LogEntry](bytes.NewReader(data))
+		rows := make([]LogEntry, 1024)
+		for {
+			n, err := reader.Read(rows)
+			for _, entry := range rows[:n] {
+				if !window.contains(entry.Timestamp) || (*level != "" && entry.Level != *level) ||
+					(*hasHash != "" && entry.ContentHash != *hasHash) || !matches(entry.Message) {
+					continue
+				}
+				if err := encoder.Encode(entry); err != nil {
+					log.Fatalf("Error writing output: %v", err)
+				}
+				hits++
+				if *limit > 0 && hits >= *limit {
+					reader.Close()
+					log.Printf("Stopped at -limit %d after scanning %d files", *limit, scanned)
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("Error reading %s: %v", key, err)
+				}
+				break
+			}
+		}
+		reader.Close()
+	}
+	log.Printf("%d matches in %d files (%d skipped by bloom filter)", hits, scanned, skipped)
+}
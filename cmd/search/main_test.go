@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartitionMatchesLocalTime(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+
+	from, err := parseTimeFlag("2026-01-02", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parseTimeFlag("2026-01-02", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 1, 1, 22, 0, 0, 0, time.UTC); !from.Equal(want) {
+		t.Errorf("-from 2026-01-02 = %v, want local midnight %v", from, want)
+	}
+	if want := time.Date(2026, 1, 2, 21, 59, 59, 999999999, time.UTC); !to.Equal(want) {
+		t.Errorf("-to 2026-01-02 = %v, want the end of the local day %v", to, want)
+	}
+
+	// 23:30Z on Jan 1 is 01:30 on Jan 2 in UTC+2, where the ingestor put it
+	window := timeRange{
+		from: time.Date(2026, 1, 1, 23, 30, 0, 0, time.UTC),
+		to:   time.Date(2026, 1, 1, 23, 45, 0, 0, time.UTC),
+	}
+	for key, want := range map[string]bool{
+		"date=2026-01-02/level=error/a.parquet":         true,
+		"date=2026-01-02/hour=01/level=error/a.parquet": true,
+		"date=2026-01-02/hour=00/level=error/a.parquet": false,
+		"date=2026-01-01/hour=23/level=error/a.parquet": false,
+		"date=2026-01-01/level=error/a.parquet":         false,
+	} {
+		if got := partitionMatches(key, window); got != want {
+			t.Errorf("partitionMatches(%s) = %v, want %v", key, got, want)
+		}
+	}
+}
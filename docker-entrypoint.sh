@@ -41,5 +41,9 @@ if [ -n "$LEVEL_FIELDS" ]; then
     CMD="$CMD -level-fields=$LEVEL_FIELDS"
 fi
 
+if [ -n "$AUTH_TOKEN" ]; then
+    CMD="$CMD -auth-token=$AUTH_TOKEN"
+fi
+
 # Execute the command
 exec $CMD
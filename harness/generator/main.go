@@ -3,6 +3,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"flag"
@@ -11,7 +12,12 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,10 +26,23 @@ var (
 	output    = flag.String("output", "", "Output file path (writes to stdout if not specified)")
 	stream    = flag.Bool("stream", false, "Stream mode: continuously generate logs (Ctrl+C to stop)")
 	delay     = flag.Duration("delay", 1*time.Second, "Delay between logs in stream mode (e.g., 100ms, 1s, 2s)")
+	rate      = flag.Float64("rate", 0, "Pace output to this many logs/sec with a token bucket, in stream and fixed-count modes (replaces -delay)")
+	burst     = flag.Int("burst", 0, "With -rate, logs that may be emitted at once to catch up after a stall (0 allows 10ms worth)")
 	startDate = flag.String("start-date", "", "Start date for log timestamps (format: 2006-01-02, default: today)")
 	days      = flag.Int("days", 1, "Number of days to span logs across")
 	endpoint  = flag.String("endpoint", "", "HTTP endpoint to POST logs to (e.g., http://localhost:8080/ingest)")
 	batch     = flag.Int("batch", 1, "Number of logs to batch together before sending (only with -endpoint)")
+	benchmark = flag.Bool("benchmark", false, "Benchmark mode: POST -count logs to -endpoint as fast as possible and report throughput")
+	svcCount  = flag.Int("num-services", 0, "Number of distinct service.name values to use (0 uses the built-in set)")
+	hostCount = flag.Int("num-hosts", 0, "Number of distinct resource host.name values to use (0 omits host.name)")
+	dupRate   = flag.Float64("duplicate-rate", 0, "Probability (0.0-1.0) of re-emitting the previous log verbatim, to test deduplication")
+	chaosRate = flag.Float64("chaos-rate", 0, "Fraction (0.0-1.0) of deliberately malformed lines in the -format: truncated lines, bad or missing timestamps, 1MB messages, NUL bytes, invalid UTF-8")
+	replayLog = flag.String("replay-file", "", "Replay a captured JSON log file with timestamps shifted to now, keeping relative timing")
+	speedup   = flag.Float64("speedup", 1, "With -replay-file, divide inter-arrival gaps by this factor")
+	seed      = flag.Int64("seed", 0, "Seed for reproducible output (0 uses a time-based seed)")
+	workers   = flag.Int("concurrency", 1, "Number of parallel HTTP senders (only with -endpoint)")
+	format    = flag.String("format", "json", "Output format: json (OpenTelemetry), apache (combined access log, error log for errors), logfmt, or syslog (RFC5424)")
+	patterns  = flag.String("patterns-file", "", "JSON file overriding the built-in patterns, services, endpoints, methods, status codes and error vocabularies")
 )
 
 func usage() {
@@ -44,19 +63,39 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "  %s -count 50000 -days 30 -output logs.json\n\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  # Stream logs continuously with 500ms delay\n")
 	fmt.Fprintf(os.Stderr, "  %s -stream -delay 500ms\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  # Stream 5000 logs/sec to the ingestor in batches of 100\n")
+	fmt.Fprintf(os.Stderr, "  %s -stream -rate 5000 -endpoint http://localhost:8080/ingest -batch 100\n\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  # Pipe directly to ingestor\n")
 	fmt.Fprintf(os.Stderr, "  %s -count 10000 | curl -X POST --data-binary @- http://localhost:8080/ingest\n\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  # Stream logs directly to HTTP endpoint\n")
 	fmt.Fprintf(os.Stderr, "  %s -stream -delay 500ms -endpoint http://localhost:8080/ingest\n\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  # POST logs in batches\n")
 	fmt.Fprintf(os.Stderr, "  %s -count 10000 -endpoint http://localhost:8080/ingest -batch 100\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  # Reproducible output for golden-file tests\n")
+	fmt.Fprintf(os.Stderr, "  %s -count 1000 -seed 42 -start-date 2024-01-01\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  # Replay a captured log file 10x faster than real time\n")
+	fmt.Fprintf(os.Stderr, "  %s -replay-file prod.json -speedup 10 -endpoint http://localhost:8080/ingest -batch 100\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  # Exercise the ingestor's plaintext parsing with Apache-style lines\n")
+	fmt.Fprintf(os.Stderr, "  %s -count 1000 -format apache -start-date 2024-01-01\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  # Mimic your own application's log vocabulary\n")
+	fmt.Fprintf(os.Stderr, "  %s -count 1000 -patterns-file patterns.json\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  # where patterns.json holds any of:\n")
+	fmt.Fprintf(os.Stderr, "  #   {\"patterns\": [{\"level\": \"error\", \"template\": \"Checkout failed for {user_id}: {error}\"}],\n")
+	fmt.Fprintf(os.Stderr, "  #    \"services\": [\"cart\"], \"endpoints\": [\"/cart\"], \"methods\": [\"GET\"],\n")
+	fmt.Fprintf(os.Stderr, "  #    \"status_codes\": {\"200\": 95, \"500\": 5}, \"error_codes\": [\"ERR_CART\"], \"error_messages\": [\"out of stock\"]}\n\n")
+	fmt.Fprintf(os.Stderr, "  # Measure ingest throughput with 8 parallel senders\n")
+	fmt.Fprintf(os.Stderr, "  %s -benchmark -count 100000 -endpoint http://localhost:8080/ingest -batch 500 -concurrency 8\n\n", os.Args[0])
 }
 
 func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	rand.Seed(time.Now().UnixNano())
+	// A fixed seed makes output reproducible (combine with -start-date)
+	seedValue := *seed
+	if seedValue == 0 {
+		seedValue = time.Now().UnixNano()
+	}
 
 	// Parse date range
 	var startTime time.Time
@@ -86,7 +125,67 @@ func main() {
 		writer = f
 	}
 
-	generator := &LogGenerator{startTime: startTime, endTime: endTime}
+	// Replay mode: re-emit a captured log file shifted to the current time
+	if *replayLog != "" {
+		if *speedup <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: -speedup must be positive\n")
+			os.Exit(1)
+		}
+		if err := replayFile(*replayLog, *speedup, writer, *endpoint, max(*batch, 1)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error replaying %s: %v\n", *replayLog, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *dupRate < 0 || *dupRate > 1 || *chaosRate < 0 || *chaosRate > 1 {
+		fmt.Fprintf(os.Stderr, "Error: -duplicate-rate and -chaos-rate must be between 0.0 and 1.0\n")
+		os.Exit(1)
+	}
+
+	delaySet := false
+	flag.Visit(func(f *flag.Flag) { delaySet = delaySet || f.Name == "delay" })
+	if *rate < 0 || *burst < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -rate and -burst must not be negative\n")
+		os.Exit(1)
+	}
+	if *rate > 0 && delaySet {
+		fmt.Fprintf(os.Stderr, "Error: -rate and -delay are mutually exclusive\n")
+		os.Exit(1)
+	}
+	if *benchmark && (*endpoint == "" || *stream) {
+		fmt.Fprintf(os.Stderr, "Error: -benchmark posts a fixed -count of logs and needs -endpoint without -stream\n")
+		os.Exit(1)
+	}
+	if *rate > 0 && *benchmark {
+		fmt.Fprintf(os.Stderr, "Error: -benchmark sends as fast as possible and cannot be combined with -rate\n")
+		os.Exit(1)
+	}
+	limiter := NewRateLimiter(*rate, *burst)
+
+	if *patterns != "" {
+		if err := loadPatterns(*patterns); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading %s: %v\n", *patterns, err)
+			os.Exit(1)
+		}
+	}
+
+	switch *format {
+	case "json", "apache", "logfmt", "syslog":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown -format %q (expected json, apache, logfmt or syslog)\n", *format)
+		os.Exit(1)
+	}
+
+	generator := NewLogGenerator(startTime, endTime, seedValue, *dupRate)
+	generator.chaosRate = *chaosRate
+	generator.format = *format
+	if *svcCount > 0 {
+		generator.services = namePool(services, *svcCount, "service-%d")
+	}
+	if *hostCount > 0 {
+		generator.hosts = namePool(nil, *hostCount, "host-%d")
+	}
 
 	if !*stream {
 		fmt.Fprintf(os.Stderr, "Generating JSON logs from %s to %s (%d days)...\n",
@@ -98,9 +197,13 @@ func main() {
 	// HTTP endpoint mode
 	if *endpoint != "" {
 		if *stream {
-			streamToHTTP(generator, *endpoint, *delay, *batch)
+			streamToHTTP(generator, *endpoint, *delay, limiter, *batch, max(*workers, 1))
 		} else {
-			batchToHTTP(generator, *endpoint, *count, *batch)
+			var stats *benchmarkStats
+			if *benchmark {
+				stats = &benchmarkStats{}
+			}
+			batchToHTTP(generator, *endpoint, *count, limiter, max(*batch, 1), max(*workers, 1), stats)
 		}
 		return
 	}
@@ -108,9 +211,16 @@ func main() {
 	// File/stdout mode
 	if *stream {
 		// Stream mode: generate logs continuously
-		fmt.Fprintf(os.Stderr, "Stream mode: generating logs every %v (Ctrl+C to stop)\n", *delay)
+		if limiter != nil {
+			fmt.Fprintf(os.Stderr, "Stream mode: generating %s (Ctrl+C to stop)\n", limiter)
+		} else {
+			fmt.Fprintf(os.Stderr, "Stream mode: generating logs every %v (Ctrl+C to stop)\n", *delay)
+		}
 		generated := 0
 		for {
+			if limiter != nil {
+				limiter.Wait(1)
+			}
 			log := generator.Generate()
 			fmt.Fprintln(writer, log)
 			generated++
@@ -119,11 +229,14 @@ func main() {
 				fmt.Fprintf(os.Stderr, "Generated %d logs...\n", generated)
 			}
 
-			time.Sleep(*delay)
+			if limiter == nil {
+				time.Sleep(*delay)
+			}
 		}
 	} else {
 		// Fixed count mode
 		for i := 0; i < *count; i++ {
+			limiter.Wait(1)
 			log := generator.Generate()
 			fmt.Fprintln(writer, log)
 
@@ -132,96 +245,498 @@ func main() {
 			}
 		}
 		fmt.Fprintf(os.Stderr, "Successfully generated %d JSON logs\n", *count)
+		generator.reportDuplicates()
 	}
 }
 
-// streamToHTTP continuously generates and POSTs logs to HTTP endpoint
-func streamToHTTP(generator *LogGenerator, endpoint string, delay time.Duration, batchSize int) {
-	fmt.Fprintf(os.Stderr, "Streaming logs to %s every %v (batch size: %d)\n", endpoint, delay, batchSize)
+// streamToHTTP continuously generates and POSTs logs to HTTP endpoint from
+// concurrency parallel senders, pacing them with limiter when set and
+// otherwise sleeping delay between batches
+func streamToHTTP(generator *LogGenerator, endpoint string, delay time.Duration, limiter *RateLimiter, batchSize, concurrency int) {
+	if limiter != nil {
+		fmt.Fprintf(os.Stderr, "Streaming logs to %s at %s (batch size: %d, senders: %d)\n", endpoint, limiter, batchSize, concurrency)
+	} else {
+		fmt.Fprintf(os.Stderr, "Streaming logs to %s every %v (batch size: %d, senders: %d)\n", endpoint, delay, batchSize, concurrency)
+	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	generated := 0
-	buffer := &bytes.Buffer{}
+	var generated atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func(gen *LogGenerator) {
+			defer wg.Done()
+			client := &http.Client{Timeout: 10 * time.Second}
+			buffer := &bytes.Buffer{}
 
-	for {
-		// Generate batch
-		for i := 0; i < batchSize; i++ {
-			log := generator.Generate()
-			buffer.WriteString(log)
-			buffer.WriteString("\n")
-		}
+			for {
+				limiter.Wait(batchSize)
 
-		// POST to endpoint
-		resp, err := client.Post(endpoint, "application/json", buffer)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error posting to %s: %v\n", endpoint, err)
-		} else {
-			io.Copy(io.Discard, resp.Body)
-			resp.Body.Close()
-			generated += batchSize
+				// Generate batch
+				for i := 0; i < batchSize; i++ {
+					log := gen.Generate()
+					buffer.WriteString(log)
+					buffer.WriteString("\n")
+				}
 
-			if generated%100 == 0 {
-				fmt.Fprintf(os.Stderr, "Posted %d logs to %s\n", generated, endpoint)
+				// POST to endpoint
+				if err := postBatch(client, endpoint, buffer.Bytes()); err != nil {
+					fmt.Fprintf(os.Stderr, "Error posting to %s: %v\n", endpoint, err)
+				} else {
+					total := generated.Add(int64(batchSize))
+					if total%100 < int64(batchSize) {
+						fmt.Fprintf(os.Stderr, "Posted %d logs to %s\n", total, endpoint)
+					}
+				}
+
+				buffer.Reset()
+				if limiter == nil {
+					time.Sleep(delay)
+				}
 			}
+		}(senderGenerator(generator, w, concurrency))
+	}
+	wg.Wait()
+}
+
+// batchToHTTP generates fixed count of logs and POSTs in batches, splitting
+// the batches across concurrency parallel senders and pacing them with
+// limiter when set. With stats set it records every request and reports
+// throughput and latency at the end.
+func batchToHTTP(generator *LogGenerator, endpoint string, count int, limiter *RateLimiter, batchSize, concurrency int, stats *benchmarkStats) {
+	fmt.Fprintf(os.Stderr, "Posting %d logs to %s (batch size: %d, senders: %d)\n", count, endpoint, batchSize, concurrency)
+	start := time.Now()
+
+	// Hand out batch sizes so every sender stays busy until count is reached
+	sizes := make(chan int)
+	go func() {
+		for remaining := count; remaining > 0; remaining -= batchSize {
+			sizes <- min(batchSize, remaining)
 		}
+		close(sizes)
+	}()
 
-		buffer.Reset()
-		time.Sleep(delay)
+	var posted, failed atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func(gen *LogGenerator) {
+			defer wg.Done()
+			client := &http.Client{Timeout: 30 * time.Second}
+			buffer := &bytes.Buffer{}
+
+			for size := range sizes {
+				limiter.Wait(size)
+				for i := 0; i < size; i++ {
+					log := gen.Generate()
+					buffer.WriteString(log)
+					buffer.WriteString("\n")
+				}
+
+				reqStart := time.Now()
+				err := postBatch(client, endpoint, buffer.Bytes())
+				stats.record(time.Since(reqStart), size, buffer.Len(), err)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error posting to %s: %v\n", endpoint, err)
+					failed.Add(int64(size))
+				} else {
+					before := posted.Add(int64(size)) - int64(size)
+					if (before+int64(size))/1000 > before/1000 {
+						fmt.Fprintf(os.Stderr, "Posted %d/%d logs...\n", before+int64(size), count)
+					}
+				}
+				buffer.Reset()
+			}
+		}(senderGenerator(generator, w, concurrency))
+	}
+	wg.Wait()
+	stats.report(time.Since(start))
+
+	if n := failed.Load(); n > 0 {
+		fmt.Fprintf(os.Stderr, "Posted %d logs to %s (%d failed)\n", posted.Load(), endpoint, n)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Successfully posted %d logs to %s\n", posted.Load(), endpoint)
+	generator.reportDuplicates()
+}
+
+// senderGenerator returns the generator for sender w; a single sender uses
+// the shared generator so its output matches file mode for the same seed
+func senderGenerator(generator *LogGenerator, w, concurrency int) *LogGenerator {
+	if concurrency == 1 {
+		return generator
 	}
+	return generator.Fork(w)
 }
 
-// batchToHTTP generates fixed count of logs and POSTs in batches
-func batchToHTTP(generator *LogGenerator, endpoint string, count, batchSize int) {
-	fmt.Fprintf(os.Stderr, "Posting %d logs to %s (batch size: %d)\n", count, endpoint, batchSize)
+// replayTimestamp matches the timestamp field rewritten during replay
+var replayTimestamp = regexp.MustCompile(`"(timestamp|time|@timestamp)"\s*:\s*"([^"]+)"`)
+
+// replayFile emits each line of path with its timestamp moved forward so the
+// first line lands at the current time, sleeping between lines so the original
+// gaps are preserved (divided by speedup). Lines are written to writer, or
+// POSTed in batches when endpoint is set.
+func replayFile(path string, speedup float64, writer io.Writer, endpoint string, batchSize int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
 	client := &http.Client{Timeout: 30 * time.Second}
 	buffer := &bytes.Buffer{}
-	posted := 0
-
-	for i := 0; i < count; i++ {
-		log := generator.Generate()
-		buffer.WriteString(log)
-		buffer.WriteString("\n")
-
-		// Send batch when full or at end
-		if (i+1)%batchSize == 0 || i == count-1 {
-			resp, err := client.Post(endpoint, "application/json", buffer)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error posting to %s: %v\n", endpoint, err)
-			} else {
-				io.Copy(io.Discard, resp.Body)
-				resp.Body.Close()
-				posted += buffer.Len()
+	pending := 0
+	flush := func() {
+		if endpoint == "" || pending == 0 {
+			return
+		}
+		if err := postBatch(client, endpoint, buffer.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error posting to %s: %v\n", endpoint, err)
+		}
+		buffer.Reset()
+		pending = 0
+	}
+
+	var firstOriginal time.Time
+	replayStart := time.Now()
+	replayed := 0
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		if match := replayTimestamp.FindStringSubmatchIndex(line); match != nil {
+			original, err := time.Parse(time.RFC3339Nano, line[match[4]:match[5]])
+			if err == nil {
+				if firstOriginal.IsZero() {
+					firstOriginal = original
+				}
+
+				// Wait until this line is due, sending what we have first
+				offset := time.Duration(float64(original.Sub(firstOriginal)) / speedup)
+				if wait := time.Until(replayStart.Add(offset)); wait > 0 {
+					flush()
+					time.Sleep(wait)
+				}
+
+				shifted := time.Now().Format(time.RFC3339Nano)
+				line = line[:match[4]] + shifted + line[match[5]:]
 			}
-			buffer.Reset()
+		}
 
-			if (i+1)%1000 == 0 {
-				fmt.Fprintf(os.Stderr, "Posted %d/%d logs...\n", i+1, count)
+		if endpoint == "" {
+			fmt.Fprintln(writer, line)
+		} else {
+			buffer.WriteString(line)
+			buffer.WriteString("\n")
+			if pending++; pending >= batchSize {
+				flush()
 			}
 		}
+
+		replayed++
+		if replayed%1000 == 0 {
+			fmt.Fprintf(os.Stderr, "Replayed %d logs...\n", replayed)
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Replayed %d logs from %s\n", replayed, path)
+	return nil
+}
+
+// postBatch POSTs one batch of newline-delimited logs
+func postBatch(client *http.Client, endpoint string, body []byte) error {
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// RateLimiter paces emission with a token bucket shared by all senders. Each
+// log costs one token; a nil limiter never waits.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter for rate logs/sec, or nil when rate is 0.
+// A burst of 0 allows 10ms worth of logs, which absorbs sleep overshoot that
+// would otherwise hold high rates below target.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = max(int(rate/100), 1)
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until n logs can be emitted within the rate. Tokens may go
+// negative, so a batch larger than the burst waits for its full cost and
+// oversleeping is made up on the next call.
+func (l *RateLimiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
 	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
 
-	fmt.Fprintf(os.Stderr, "Successfully posted %d logs to %s\n", count, endpoint)
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// String describes the rate for logging
+func (l *RateLimiter) String() string {
+	return fmt.Sprintf("%.0f logs/sec", l.rate)
+}
+
+// benchmarkStats collects per-request results for -benchmark; a nil
+// benchmarkStats records nothing
+type benchmarkStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	lines     int
+	bytes     int
+	errors    int
+}
+
+// record adds one request of lines logs and size bytes
+func (s *benchmarkStats) record(latency time.Duration, lines, size int, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies = append(s.latencies, latency)
+	if err != nil {
+		s.errors++
+		return
+	}
+	s.lines += lines
+	s.bytes += size
 }
 
-// LogGenerator generates OpenTelemetry-compliant structured JSON logs
+// report prints throughput over elapsed and the request latency percentiles
+func (s *benchmarkStats) report(elapsed time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+
+	seconds := elapsed.Seconds()
+	fmt.Fprintf(os.Stderr, "\nBenchmark results:\n")
+	fmt.Fprintf(os.Stderr, "  Duration:      %v\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(os.Stderr, "  Lines sent:    %d\n", s.lines)
+	fmt.Fprintf(os.Stderr, "  Requests:      %d (%d errors)\n", len(s.latencies), s.errors)
+	fmt.Fprintf(os.Stderr, "  Lines/sec:     %.0f\n", float64(s.lines)/seconds)
+	fmt.Fprintf(os.Stderr, "  Bytes/sec:     %.0f (%.2f MB/s)\n", float64(s.bytes)/seconds, float64(s.bytes)/seconds/(1<<20))
+	fmt.Fprintf(os.Stderr, "  Latency p50:   %v\n", percentile(s.latencies, 0.50))
+	fmt.Fprintf(os.Stderr, "  Latency p95:   %v\n", percentile(s.latencies, 0.95))
+}
+
+// percentile returns the p-th percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx].Round(time.Microsecond)
+}
+
+// LogGenerator generates OpenTelemetry-compliant structured JSON logs. It is
+// not safe for concurrent use; give each sender its own via Fork.
 type LogGenerator struct {
 	startTime time.Time
 	endTime   time.Time
+	seed      int64
+	rng       *rand.Rand
+
+	duplicateRate float64
+	duplicates    *atomic.Int64 // shared with forks
+	last          string
+	chaosRate     float64
+	chaos         *atomic.Int64 // shared with forks
+
+	services []string
+	hosts    []string // resource host.name is omitted when empty
+	format   string   // json unless set by -format
+}
+
+// NewLogGenerator creates a generator whose output is determined by seed
+func NewLogGenerator(startTime, endTime time.Time, seed int64, duplicateRate float64) *LogGenerator {
+	return &LogGenerator{
+		startTime:     startTime,
+		endTime:       endTime,
+		seed:          seed,
+		rng:           rand.New(rand.NewSource(seed)),
+		duplicateRate: duplicateRate,
+		duplicates:    &atomic.Int64{},
+		chaos:         &atomic.Int64{},
+		services:      services,
+	}
+}
+
+// namePool returns exactly n names, taking from base first and then
+// numbering with format, so generated output has a known cardinality
+func namePool(base []string, n int, format string) []string {
+	if n <= len(base) {
+		return base[:n]
+	}
+	pool := append([]string(nil), base...)
+	for i := len(base); i < n; i++ {
+		pool = append(pool, fmt.Sprintf(format, i+1))
+	}
+	return pool
+}
+
+// Fork returns an independent generator for sender i, seeded from this one's
+// seed so concurrent runs stay reproducible
+func (g *LogGenerator) Fork(i int) *LogGenerator {
+	fork := NewLogGenerator(g.startTime, g.endTime, g.seed+int64(i)+1, g.duplicateRate)
+	fork.duplicates = g.duplicates
+	fork.chaosRate = g.chaosRate
+	fork.chaos = g.chaos
+	fork.services = g.services
+	fork.hosts = g.hosts
+	fork.format = g.format
+	return fork
+}
+
+// reportDuplicates prints how many duplicate and malformed lines were injected
+func (g *LogGenerator) reportDuplicates() {
+	if g.duplicateRate > 0 {
+		fmt.Fprintf(os.Stderr, "Injected %d duplicate logs (rate %.2f)\n", g.duplicates.Load(), g.duplicateRate)
+	}
+	if g.chaosRate > 0 {
+		fmt.Fprintf(os.Stderr, "Injected %d malformed logs (rate %.2f)\n", g.chaos.Load(), g.chaosRate)
+	}
 }
 
+// Generate returns the next log line. With -duplicate-rate, the previous line
+// is sometimes re-emitted verbatim to exercise deduplication.
 func (g *LogGenerator) Generate() string {
+	if g.last != "" && g.duplicateRate > 0 && g.rng.Float64() < g.duplicateRate {
+		g.duplicates.Add(1)
+		return g.last
+	}
+	if g.chaosRate > 0 && g.rng.Float64() < g.chaosRate {
+		g.chaos.Add(1)
+		return g.malformed()
+	}
+	g.last = g.generate()
+	return g.last
+}
+
+// malformed returns a deliberately broken line in the generator's format to
+// exercise the ingestor's error paths. Lines never contain newlines so record
+// boundaries stay intact.
+func (g *LogGenerator) malformed() string {
+	line := g.generate()
+	structured := g.format == "" || g.format == "json"
+	switch g.rng.Intn(6) {
+	case 0: // truncated line
+		return line[:g.rng.Intn(len(line)-1)+1]
+	case 1: // unparseable timestamp
+		if structured {
+			return strings.Replace(line, `"timestamp":"`, `"timestamp":"not-a-time `, 1)
+		}
+		return strings.Replace(line, g.lineTimestamp(line), "not-a-time", 1)
+	case 2: // missing timestamp
+		timestamp := g.lineTimestamp(line)
+		switch g.format {
+		case "apache":
+			return strings.Replace(line, "["+timestamp+"] ", "", 1)
+		case "logfmt":
+			return strings.TrimPrefix(line, timestamp+" ")
+		case "syslog":
+			return strings.Replace(line, timestamp, "-", 1) // the RFC5424 nil value
+		}
+		return strings.Replace(line, `"timestamp":`, `"ts_missing":`, 1)
+	case 3: // megabyte message
+		// Stay within the date range so seeded output is reproducible
+		timestamp := time.Now()
+		if !g.startTime.IsZero() {
+			timestamp = g.randomTime(g.startTime, g.endTime)
+		}
+		body := strings.Repeat("x", 1<<20)
+		resource := map[string]interface{}{"service.name": g.randomChoice(g.services)}
+		switch g.format {
+		case "apache":
+			return g.formatApache(timestamp, "error", body, nil)
+		case "logfmt":
+			return formatLogfmt(timestamp, "info", body, resource, g.generateTraceID())
+		case "syslog":
+			return formatSyslog(timestamp, "info", body, resource)
+		}
+		return fmt.Sprintf(`{"timestamp":"%s","severityText":"INFO","body":"%s"}`,
+			timestamp.Format(time.RFC3339Nano), body)
+	case 4: // embedded null byte
+		mid := len(line) / 2
+		return line[:mid] + "\x00" + line[mid:]
+	default: // invalid UTF-8
+		if structured {
+			return strings.Replace(line, `"body":"`, "\"body\":\"\xff\xfe", 1)
+		}
+		mid := len(line) / 2
+		return line[:mid] + "\xff\xfe" + line[mid:]
+	}
+}
+
+// lineTimestamp returns the timestamp text of an apache, logfmt or syslog line
+func (g *LogGenerator) lineTimestamp(line string) string {
+	switch g.format {
+	case "apache":
+		return line[strings.IndexByte(line, '[')+1 : strings.IndexByte(line, ']')]
+	case "logfmt":
+		return strings.Fields(line)[0]
+	case "syslog":
+		return strings.Fields(line)[1] // after "<pri>1"
+	}
+	return ""
+}
+
+func (g *LogGenerator) generate() string {
 	var timestamp time.Time
 	if !g.startTime.IsZero() {
 		// Generate random timestamp within the date range
-		timestamp = randomTime(g.startTime, g.endTime)
+		timestamp = g.randomTime(g.startTime, g.endTime)
 	} else {
 		timestamp = time.Now()
 	}
 
-	pattern := webAppPatterns[rand.Intn(len(webAppPatterns))]
-	traceID := generateTraceID()
-	spanID := generateSpanID()
+	pattern := webAppPatterns[g.rng.Intn(len(webAppPatterns))]
+	traceID := g.generateTraceID()
+	spanID := g.generateSpanID()
 
 	// Map level to OpenTelemetry severity
 	severityMap := map[string]int{
@@ -239,28 +754,47 @@ func (g *LogGenerator) Generate() string {
 	attributes := make(map[string]interface{})
 
 	// Add HTTP attributes if applicable
-	if rand.Float32() < 0.7 {
-		attributes["http.method"] = randomChoice(httpMethods)
-		attributes["http.route"] = randomChoice(endpoints)
-		attributes["http.status_code"] = statusCodes[rand.Intn(len(statusCodes))]
-		attributes["http.request_id"] = generateRequestID()
-		attributes["http.user_id"] = fmt.Sprintf("user_%d", rand.Intn(10000))
-		attributes["http.duration_ms"] = rand.Intn(5000)
+	if g.rng.Float32() < 0.7 {
+		attributes["http.method"] = g.randomChoice(httpMethods)
+		attributes["http.route"] = g.randomChoice(endpoints)
+		attributes["http.status_code"] = statusCodes[g.rng.Intn(len(statusCodes))]
+		attributes["http.request_id"] = g.generateRequestID()
+		attributes["http.user_id"] = fmt.Sprintf("user_%d", g.rng.Intn(10000))
+		attributes["http.duration_ms"] = g.rng.Intn(5000)
 	}
 
 	// Add error attributes
 	if pattern.Level == "error" {
-		attributes["error.type"] = randomChoice(errorCodes)
-		attributes["exception.message"] = randomChoice(errorMessages)
-		if rand.Float32() < 0.6 {
-			attributes["exception.stacktrace"] = generateStackTrace()
+		attributes["error.type"] = g.randomChoice(errorCodes)
+		attributes["exception.message"] = g.randomChoice(errorMessages)
+		if g.rng.Float32() < 0.6 {
+			attributes["exception.stacktrace"] = g.generateStackTrace()
 		}
 	}
 
 	// Add database attributes
-	if rand.Float32() < 0.3 {
-		attributes["db.system"] = randomChoice(databases)
-		attributes["db.operation"] = randomChoice([]string{"SELECT", "INSERT", "UPDATE", "DELETE"})
+	if g.rng.Float32() < 0.3 {
+		attributes["db.system"] = g.randomChoice(databases)
+		attributes["db.operation"] = g.randomChoice([]string{"SELECT", "INSERT", "UPDATE", "DELETE"})
+	}
+
+	body := g.formatMessage(pattern.Template)
+	resource := map[string]interface{}{
+		"service.name":           g.randomChoice(g.services),
+		"service.version":        fmt.Sprintf("1.%d.%d", g.rng.Intn(10), g.rng.Intn(20)),
+		"deployment.environment": g.randomChoice([]string{"production", "staging", "development"}),
+	}
+	if len(g.hosts) > 0 {
+		resource["host.name"] = g.randomChoice(g.hosts)
+	}
+
+	switch g.format {
+	case "apache":
+		return g.formatApache(timestamp, pattern.Level, body, attributes)
+	case "logfmt":
+		return formatLogfmt(timestamp, pattern.Level, body, resource, traceID)
+	case "syslog":
+		return formatSyslog(timestamp, pattern.Level, body, resource)
 	}
 
 	// OpenTelemetry log record structure
@@ -269,15 +803,11 @@ func (g *LogGenerator) Generate() string {
 		"observedTimestamp": timestamp.Format(time.RFC3339Nano),
 		"severityNumber":    severityNumber,
 		"severityText":      strings.ToUpper(pattern.Level),
-		"body":              g.formatMessage(pattern.Template),
+		"body":              body,
 		"traceId":           traceID,
 		"spanId":            spanID,
-		"resource": map[string]interface{}{
-			"service.name":           randomChoice(services),
-			"service.version":        fmt.Sprintf("1.%d.%d", rand.Intn(10), rand.Intn(20)),
-			"deployment.environment": randomChoice([]string{"production", "staging", "development"}),
-		},
-		"attributes": attributes,
+		"resource":          resource,
+		"attributes":        attributes,
 	}
 
 	// Convert to JSON
@@ -287,20 +817,20 @@ func (g *LogGenerator) Generate() string {
 
 func (g *LogGenerator) formatMessage(template string) string {
 	replacements := map[string]string{
-		"{user_id}":    fmt.Sprintf("user_%d", rand.Intn(10000)),
-		"{endpoint}":   randomChoice(endpoints),
-		"{method}":     randomChoice(httpMethods),
-		"{status}":     fmt.Sprintf("%d", statusCodes[rand.Intn(len(statusCodes))]),
-		"{duration}":   fmt.Sprintf("%d", rand.Intn(5000)),
-		"{error}":      randomChoice(errorMessages),
-		"{ip}":         generateIP(),
-		"{count}":      fmt.Sprintf("%d", rand.Intn(1000)),
-		"{threshold}":  fmt.Sprintf("%d", rand.Intn(100)),
-		"{database}":   randomChoice(databases),
-		"{queue}":      randomChoice(queues),
-		"{cache_key}":  fmt.Sprintf("cache:%s:%d", randomChoice(cacheKeys), rand.Intn(10000)),
-		"{bytes}":      fmt.Sprintf("%d", rand.Intn(1000000)),
-		"{percentage}": fmt.Sprintf("%.2f", rand.Float64()*100),
+		"{user_id}":    fmt.Sprintf("user_%d", g.rng.Intn(10000)),
+		"{endpoint}":   g.randomChoice(endpoints),
+		"{method}":     g.randomChoice(httpMethods),
+		"{status}":     fmt.Sprintf("%d", statusCodes[g.rng.Intn(len(statusCodes))]),
+		"{duration}":   fmt.Sprintf("%d", g.rng.Intn(5000)),
+		"{error}":      g.randomChoice(errorMessages),
+		"{ip}":         g.generateIP(),
+		"{count}":      fmt.Sprintf("%d", g.rng.Intn(1000)),
+		"{threshold}":  fmt.Sprintf("%d", g.rng.Intn(100)),
+		"{database}":   g.randomChoice(databases),
+		"{queue}":      g.randomChoice(queues),
+		"{cache_key}":  fmt.Sprintf("cache:%s:%d", g.randomChoice(cacheKeys), g.rng.Intn(10000)),
+		"{bytes}":      fmt.Sprintf("%d", g.rng.Intn(1000000)),
+		"{percentage}": fmt.Sprintf("%.2f", g.rng.Float64()*100),
 	}
 
 	result := template
@@ -310,30 +840,76 @@ func (g *LogGenerator) formatMessage(template string) string {
 	return result
 }
 
+// formatApache renders error records as Apache error-log lines
+// ("[Mon Jan 02 15:04:05 2006] [error] ...") and everything else as
+// combined access-log lines, using the record's HTTP attributes when present
+func (g *LogGenerator) formatApache(timestamp time.Time, level, body string, attributes map[string]interface{}) string {
+	if level == "error" {
+		return fmt.Sprintf("[%s] [error] [client %s] %s",
+			timestamp.UTC().Format("Mon Jan 02 15:04:05 2006"), g.generateIP(), body)
+	}
+
+	method, _ := attributes["http.method"].(string)
+	route, _ := attributes["http.route"].(string)
+	status, _ := attributes["http.status_code"].(int)
+	if method == "" {
+		method, route, status = g.randomChoice(httpMethods), g.randomChoice(endpoints), statusCodes[g.rng.Intn(len(statusCodes))]
+	}
+	user := "-"
+	if id, ok := attributes["http.user_id"].(string); ok {
+		user = id
+	}
+	return fmt.Sprintf(`%s - %s [%s] "%s %s HTTP/1.1" %d %d "-" "%s"`,
+		g.generateIP(), user, timestamp.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+		method, route, status, g.rng.Intn(50000), g.randomChoice(userAgents))
+}
+
+// formatLogfmt renders a record as key=value pairs behind an RFC3339
+// timestamp prefix
+func formatLogfmt(timestamp time.Time, level, body string, resource map[string]interface{}, traceID string) string {
+	return fmt.Sprintf("%s level=%s service=%s msg=%s trace_id=%s",
+		timestamp.UTC().Format("2006-01-02T15:04:05-07:00"), level, resource["service.name"],
+		strconv.Quote(body), traceID)
+}
+
+// formatSyslog renders a record as an RFC5424 message from facility local0
+func formatSyslog(timestamp time.Time, level, body string, resource map[string]interface{}) string {
+	severity := map[string]int{"debug": 7, "info": 6, "warn": 4, "error": 3}[level]
+	if severity == 0 {
+		severity = 6
+	}
+	host, ok := resource["host.name"].(string)
+	if !ok {
+		host = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - - - %s",
+		16*8+severity, timestamp.UTC().Format(time.RFC3339Nano), host, resource["service.name"], body)
+}
+
 // Helper functions
 
-func generateIP() string {
+func (g *LogGenerator) generateIP() string {
 	return fmt.Sprintf("%d.%d.%d.%d",
-		rand.Intn(255)+1,
-		rand.Intn(256),
-		rand.Intn(256),
-		rand.Intn(255)+1,
+		g.rng.Intn(255)+1,
+		g.rng.Intn(256),
+		g.rng.Intn(256),
+		g.rng.Intn(255)+1,
 	)
 }
 
-func generateRequestID() string {
-	return fmt.Sprintf("req_%s", randomString(16))
+func (g *LogGenerator) generateRequestID() string {
+	return fmt.Sprintf("req_%s", g.randomString(16))
 }
 
-func generateTraceID() string {
-	return randomString(32)
+func (g *LogGenerator) generateTraceID() string {
+	return g.randomString(32)
 }
 
-func generateSpanID() string {
-	return randomString(16)
+func (g *LogGenerator) generateSpanID() string {
+	return g.randomString(16)
 }
 
-func generateStackTrace() string {
+func (g *LogGenerator) generateStackTrace() string {
 	traces := []string{
 		"at handleRequest (app.js:145)",
 		"at Database.query (db.js:89)",
@@ -341,7 +917,7 @@ func generateStackTrace() string {
 		"at processPayment (payment.js:456)",
 		"at sendEmail (email.js:78)",
 	}
-	numLines := rand.Intn(3) + 2
+	numLines := g.rng.Intn(3) + 2
 	result := ""
 	for i := 0; i < numLines && i < len(traces); i++ {
 		result += traces[i]
@@ -352,17 +928,17 @@ func generateStackTrace() string {
 	return result
 }
 
-func randomString(length int) string {
+func (g *LogGenerator) randomString(length int) string {
 	const charset = "abcdef0123456789"
 	result := make([]byte, length)
 	for i := range result {
-		result[i] = charset[rand.Intn(len(charset))]
+		result[i] = charset[g.rng.Intn(len(charset))]
 	}
 	return string(result)
 }
 
-func randomChoice(slice []string) string {
-	return slice[rand.Intn(len(slice))]
+func (g *LogGenerator) randomChoice(slice []string) string {
+	return slice[g.rng.Intn(len(slice))]
 }
 
 func replaceFirst(s, old, new string) string {
@@ -374,17 +950,95 @@ func replaceFirst(s, old, new string) string {
 	return s
 }
 
-func randomTime(start, end time.Time) time.Time {
+func (g *LogGenerator) randomTime(start, end time.Time) time.Time {
 	delta := end.Sub(start)
-	randomDuration := time.Duration(rand.Int63n(int64(delta)))
+	randomDuration := time.Duration(g.rng.Int63n(int64(delta)))
 	return start.Add(randomDuration)
 }
 
+// PatternsFile is the -patterns-file format. Omitted or empty lists keep the
+// built-in defaults. Status codes map to relative weights.
+type PatternsFile struct {
+	Patterns      []LogPattern   `json:"patterns"`
+	Services      []string       `json:"services"`
+	Endpoints     []string       `json:"endpoints"`
+	Methods       []string       `json:"methods"`
+	StatusCodes   map[string]int `json:"status_codes"`
+	ErrorCodes    []string       `json:"error_codes"`
+	ErrorMessages []string       `json:"error_messages"`
+}
+
+// loadPatterns replaces the built-in vocabularies with those in path.
+// Templates may use the usual placeholders; unknown ones are left as-is.
+func loadPatterns(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file PatternsFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return err
+	}
+
+	for i, pattern := range file.Patterns {
+		if pattern.Template == "" {
+			return fmt.Errorf("pattern %d has no template", i)
+		}
+		file.Patterns[i].Level = strings.ToLower(pattern.Level)
+		if file.Patterns[i].Level == "" {
+			file.Patterns[i].Level = "info"
+		}
+	}
+
+	// Expand weights into a pool so codes are drawn in proportion
+	var codes []int
+	keys := make([]string, 0, len(file.StatusCodes))
+	for key := range file.StatusCodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		code, err := strconv.Atoi(key)
+		weight := file.StatusCodes[key]
+		if err != nil || code < 100 || code > 599 || weight < 0 {
+			return fmt.Errorf("invalid status code weight %q: %d", key, weight)
+		}
+		for i := 0; i < weight; i++ {
+			codes = append(codes, code)
+		}
+	}
+
+	if len(file.Patterns) > 0 {
+		webAppPatterns = file.Patterns
+	}
+	if len(file.Services) > 0 {
+		services = file.Services
+	}
+	if len(file.Endpoints) > 0 {
+		endpoints = file.Endpoints
+	}
+	if len(file.Methods) > 0 {
+		httpMethods = file.Methods
+	}
+	if len(codes) > 0 {
+		statusCodes = codes
+	}
+	if len(file.ErrorCodes) > 0 {
+		errorCodes = file.ErrorCodes
+	}
+	if len(file.ErrorMessages) > 0 {
+		errorMessages = file.ErrorMessages
+	}
+	return nil
+}
+
 // Log pattern definitions
 
 type LogPattern struct {
-	Level    string
-	Template string
+	Level    string `json:"level"`
+	Template string `json:"template"`
 }
 
 var webAppPatterns = []LogPattern{
@@ -451,6 +1105,11 @@ var queues = []string{
 	"payment-queue", "export-queue",
 }
 
+var userAgents = []string{
+	"Mozilla/5.0 (X11; Linux x86_64)", "curl/8.5.0", "python-requests/2.31.0",
+	"Go-http-client/1.1", "kube-probe/1.29",
+}
+
 var cacheKeys = []string{
 	"user", "session", "product", "inventory", "config",
 }
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package logstore holds what the ingestor and search tools share: the
+// parquet row schema and the S3 client setup.
+package logstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// LogEntry represents a log entry that will be written to Parquet
+type LogEntry struct {
+	Timestamp   time.Time `parquet:"timestamp" json:"timestamp"`
+	Message     string    `parquet:"message" json:"message"`
+	Level       string    `parquet:"level" json:"level"`
+	LineNumber  int64     `parquet:"line_number" json:"line_number"`
+	ContentHash string    `parquet:"content_hash" json:"content_hash"`
+	DocID       string    `parquet:"doc_id,optional" json:"doc_id,omitempty"`
+	Partition   string    `parquet:"partition,optional" json:"partition,omitempty"`
+
+	// Partition values, written with -partition-as-column
+	Date        string `parquet:"date,optional" json:"date,omitempty"`
+	Hour        string `parquet:"hour,optional" json:"hour,omitempty"`
+	ServiceName string `parquet:"service,optional" json:"service,omitempty"`
+
+	ExceptionType    string `parquet:"exception_type,optional" json:"exception_type,omitempty"`
+	ExceptionMessage string `parquet:"exception_message,optional" json:"exception_message,omitempty"`
+	StackTrace       string `parquet:"stack_trace,optional" json:"stack_trace,omitempty"`
+
+	// Ingest-time only; not written to files
+	IngestTime time.Time     `parquet:"-" json:"-"`
+	Service    string        `parquet:"-" json:"-"`
+	Extracted  []interface{} `parquet:"-" json:"-"` // -extract-fields values, nil when absent
+}
+
+// S3Config is the connection settings shared by the -endpoint, -region,
+// -access-key and -secret-key flags
+type S3Config struct {
+	Endpoint  string // custom endpoint for MinIO and other S3-compatible stores
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// NewS3Client creates an S3 client. Without a custom endpoint the default AWS
+// config chain (environment, shared config, instance role) is used.
+func NewS3Client(ctx context.Context, cfg S3Config) (*s3.Client, error) {
+	var awsCfg aws.Config
+	var err error
+
+	if cfg.Endpoint != "" {
+		awsCfg, err = config.LoadDefaultConfig(ctx,
+			config.WithRegion(cfg.Region),
+		)
+	} else {
+		awsCfg, err = config.LoadDefaultConfig(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+
+			if cfg.AccessKey != "" && cfg.SecretKey != "" {
+				o.Credentials = aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+					return aws.Credentials{
+						AccessKeyID:     cfg.AccessKey,
+						SecretAccessKey: cfg.SecretKey,
+					}, nil
+				})
+			}
+		}
+	}), nil
+}